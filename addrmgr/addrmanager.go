@@ -306,6 +306,19 @@ func (a *AddrManager) pickTried(bucket int) int {
 	return idx
 }
 
+// KeyedGroupHash returns a value derived from the network group of the provided
+// address, as determined by GroupKey, mixed with the provided secret key.  It
+// is suitable for selecting buckets in a way that is consistent for a given key
+// while preventing an attacker that does not know the key from predicting the
+// bucket an address will be assigned to.
+func KeyedGroupHash(na *wire.NetAddress, key [32]byte) uint64 {
+	group := GroupKey(na)
+	data := make([]byte, 0, len(key)+len(group))
+	data = append(data, key[:]...)
+	data = append(data, group...)
+	return binary.LittleEndian.Uint64(chainhash.HashB(data))
+}
+
 func (a *AddrManager) getNewBucket(netAddr, srcAddr *wire.NetAddress) int {
 	// bitcoind:
 	// doublesha256(key + sourcegroup + int64(doublesha256(key + group
@@ -350,6 +363,27 @@ func (a *AddrManager) getTriedBucket(netAddr *wire.NetAddress) int {
 	return int(binary.LittleEndian.Uint64(hash2) % triedBucketCount)
 }
 
+// NewBucketIndex returns the index of the new address bucket the provided
+// address would be placed in when it is learned from the provided source
+// address.  The placement depends on the network groups of both addresses and
+// the secret key of the address manager, so it is only stable for a given
+// address manager instance.  It is primarily useful for diagnostics.
+func (a *AddrManager) NewBucketIndex(netAddr, srcAddr *wire.NetAddress) int {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	return a.getNewBucket(netAddr, srcAddr)
+}
+
+// TriedBucketIndex returns the index of the tried address bucket the provided
+// address would be placed in.  The placement depends on the secret key of the
+// address manager, so it is only stable for a given address manager instance.
+// It is primarily useful for diagnostics.
+func (a *AddrManager) TriedBucketIndex(netAddr *wire.NetAddress) int {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	return a.getTriedBucket(netAddr)
+}
+
 // addressHandler is the main handler for the address manager.  It must be run
 // as a goroutine.
 func (a *AddrManager) addressHandler() {
@@ -1083,9 +1117,11 @@ const (
 	Private
 )
 
-// getReachabilityFrom returns the relative reachability of the provided local
-// address to the provided remote address.
-func getReachabilityFrom(localAddr, remoteAddr *wire.NetAddress) int {
+// ReachabilityFrom returns the relative reachability of the provided local
+// address to the provided remote address.  Higher values indicate the local
+// address is better suited for reaching the remote address, while Unreachable
+// indicates it is not able to reach it at all.
+func ReachabilityFrom(localAddr, remoteAddr *wire.NetAddress) int {
 	if !IsRoutable(remoteAddr) {
 		return Unreachable
 	}
@@ -1162,7 +1198,7 @@ func (a *AddrManager) GetBestLocalAddress(remoteAddr *wire.NetAddress) *wire.Net
 	var bestscore AddressPriority
 	var bestAddress *wire.NetAddress
 	for _, la := range a.localAddresses {
-		reach := getReachabilityFrom(la.na, remoteAddr)
+		reach := ReachabilityFrom(la.na, remoteAddr)
 		if reach > bestreach ||
 			(reach == bestreach && la.score > bestscore) {
 			bestreach = reach
@@ -1194,8 +1230,8 @@ func (a *AddrManager) GetBestLocalAddress(remoteAddr *wire.NetAddress) *wire.Net
 // provided local address based on its routablility and reachability
 // from the peer that suggested it.
 func (a *AddrManager) ValidatePeerNa(localAddr, remoteAddr *wire.NetAddress) (bool, int) {
-	net := getNetwork(localAddr)
-	reach := getReachabilityFrom(localAddr, remoteAddr)
+	net := AddressType(localAddr)
+	reach := ReachabilityFrom(localAddr, remoteAddr)
 	valid := (net == IPv4Address && reach == Ipv4) || (net == IPv6Address &&
 		(reach == Ipv6Weak || reach == Ipv6Strong || reach == Teredo))
 	return valid, reach
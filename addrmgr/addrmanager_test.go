@@ -316,6 +316,94 @@ func TestConnected(t *testing.T) {
 	}
 }
 
+// TestBucketIndex ensures the bucket indices reported for an address are
+// deterministic and that the new bucket of an address depends on the network
+// group of its source.
+func TestBucketIndex(t *testing.T) {
+	n := New("testbucketindex", lookupFunc)
+	na := wire.NewNetAddressIPPort(net.ParseIP(someIP), 8333, 0)
+	srcAddr := wire.NewNetAddressIPPort(net.ParseIP("12.1.2.3"), 8333, 0)
+
+	// Ensure the placement is deterministic and within range.
+	newBucket := n.NewBucketIndex(na, srcAddr)
+	if newBucket < 0 || newBucket >= newBucketCount {
+		t.Fatalf("new bucket index %d out of range", newBucket)
+	}
+	if got := n.NewBucketIndex(na, srcAddr); got != newBucket {
+		t.Fatalf("new bucket index is not deterministic - got %d, want %d",
+			got, newBucket)
+	}
+	triedBucket := n.TriedBucketIndex(na)
+	if triedBucket < 0 || triedBucket >= triedBucketCount {
+		t.Fatalf("tried bucket index %d out of range", triedBucket)
+	}
+	if got := n.TriedBucketIndex(na); got != triedBucket {
+		t.Fatalf("tried bucket index is not deterministic - got %d, want %d",
+			got, triedBucket)
+	}
+
+	// Ensure sources in the same group place the address in the same bucket.
+	sameGroupSrc := wire.NewNetAddressIPPort(net.ParseIP("12.1.200.100"),
+		8333, 0)
+	if got := n.NewBucketIndex(na, sameGroupSrc); got != newBucket {
+		t.Fatalf("new bucket index differs for source in same group - "+
+			"got %d, want %d", got, newBucket)
+	}
+
+	// Ensure sources from different groups spread the address across
+	// multiple buckets.
+	buckets := make(map[int]struct{})
+	for i := 0; i < 64; i++ {
+		ip := net.IPv4(12, byte(i), 1, 1)
+		src := wire.NewNetAddressIPPort(ip, 8333, 0)
+		buckets[n.NewBucketIndex(na, src)] = struct{}{}
+	}
+	if len(buckets) < 2 {
+		t.Fatalf("address placed in %d bucket(s) from distinct source "+
+			"groups", len(buckets))
+	}
+}
+
+// TestKeyedGroupHash ensures the keyed group hash is stable for a given key,
+// is shared by addresses in the same group, and depends on the key.
+func TestKeyedGroupHash(t *testing.T) {
+	na := wire.NewNetAddressIPPort(net.ParseIP("12.1.2.3"), 8333, 0)
+	sameGroup := wire.NewNetAddressIPPort(net.ParseIP("12.1.200.100"), 8333, 0)
+	otherGroup := wire.NewNetAddressIPPort(net.ParseIP("173.1.2.3"), 8333, 0)
+	key1 := [32]byte{0x01}
+	key2 := [32]byte{0x02}
+
+	hash := KeyedGroupHash(na, key1)
+	if got := KeyedGroupHash(na, key1); got != hash {
+		t.Fatalf("hash is not stable for the same key - got %x, want %x",
+			got, hash)
+	}
+	if got := KeyedGroupHash(sameGroup, key1); got != hash {
+		t.Fatalf("hash differs for address in the same group - got %x, "+
+			"want %x", got, hash)
+	}
+	if got := KeyedGroupHash(otherGroup, key1); got == hash {
+		t.Fatalf("hash is the same for address in a different group: %x",
+			got)
+	}
+	if got := KeyedGroupHash(na, key2); got == hash {
+		t.Fatalf("hash is the same for a different key: %x", got)
+	}
+
+	// Ensure different keys produce different bucket assignments for the
+	// same address across a range of keys.
+	const numBuckets = 64
+	buckets := make(map[uint64]struct{})
+	for i := 0; i < 16; i++ {
+		key := [32]byte{byte(i)}
+		buckets[KeyedGroupHash(na, key)%numBuckets] = struct{}{}
+	}
+	if len(buckets) < 2 {
+		t.Fatalf("address assigned to %d bucket(s) across distinct keys",
+			len(buckets))
+	}
+}
+
 func TestNeedMoreAddresses(t *testing.T) {
 	n := New("testneedmoreaddresses", lookupFunc)
 	addrsToAdd := 1500
@@ -523,6 +611,67 @@ func TestGetBestLocalAddress(t *testing.T) {
 	*/
 }
 
+// TestReachabilityFrom ensures the relative reachability of local addresses to
+// remote addresses of the various network types matches the expected ranking.
+func TestReachabilityFrom(t *testing.T) {
+	const (
+		unroutable = "10.0.0.1"
+		ipv4       = "12.1.2.3"
+		ipv6       = "2602:100::1"
+		tunnelled  = "2002:c01:203::1"
+		teredo     = "2001:0:1234::f3fe:fdfc"
+		tor        = "fd87:d87e:eb43:1234::5678"
+	)
+
+	tests := []struct {
+		name   string
+		local  string
+		remote string
+		want   int
+	}{
+		// Unroutable remote addresses are never reachable.
+		{"ipv4 to unroutable", ipv4, unroutable, Unreachable},
+		{"ipv6 to unroutable", ipv6, unroutable, Unreachable},
+		{"tor to unroutable", tor, unroutable, Unreachable},
+
+		// Tor remote addresses.
+		{"tor to tor", tor, tor, Private},
+		{"ipv4 to tor", ipv4, tor, Ipv4},
+		{"ipv6 to tor", ipv6, tor, Default},
+		{"unroutable to tor", unroutable, tor, Default},
+
+		// Teredo remote addresses.
+		{"unroutable to teredo", unroutable, teredo, Default},
+		{"teredo to teredo", teredo, teredo, Teredo},
+		{"ipv4 to teredo", ipv4, teredo, Ipv4},
+		{"ipv6 to teredo", ipv6, teredo, Ipv6Weak},
+
+		// IPv4 remote addresses.
+		{"ipv4 to ipv4", ipv4, ipv4, Ipv4},
+		{"unroutable to ipv4", unroutable, ipv4, Unreachable},
+		{"ipv6 to ipv4", ipv6, ipv4, Unreachable},
+		{"tor to ipv4", tor, ipv4, Unreachable},
+
+		// IPv6 remote addresses.
+		{"unroutable to ipv6", unroutable, ipv6, Default},
+		{"teredo to ipv6", teredo, ipv6, Teredo},
+		{"ipv4 to ipv6", ipv4, ipv6, Ipv4},
+		{"tunnelled to ipv6", tunnelled, ipv6, Ipv6Weak},
+		{"ipv6 to ipv6", ipv6, ipv6, Ipv6Strong},
+	}
+
+	for _, test := range tests {
+		local := wire.NewNetAddressIPPort(net.ParseIP(test.local), 0,
+			wire.SFNodeNetwork)
+		remote := wire.NewNetAddressIPPort(net.ParseIP(test.remote), 0,
+			wire.SFNodeNetwork)
+		if got := ReachabilityFrom(local, remote); got != test.want {
+			t.Errorf("%q: unexpected reachability - got %v, want %v",
+				test.name, got, test.want)
+		}
+	}
+}
+
 func TestNetAddressKey(t *testing.T) {
 	addNaTests()
 
@@ -0,0 +1,59 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package addrmgr_test
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/decred/dcrd/addrmgr"
+	"github.com/decred/dcrd/wire"
+)
+
+// This example demonstrates checking whether addresses are in the private
+// network address space defined by RFC1918.
+func ExampleIsRFC1918() {
+	for _, ip := range []string{"10.0.0.1", "192.168.1.1", "8.8.8.8"} {
+		na := wire.NewNetAddressIPPort(net.ParseIP(ip), 9108, 0)
+		fmt.Printf("%s: %v\n", ip, addrmgr.IsRFC1918(na))
+	}
+
+	// Output:
+	// 10.0.0.1: true
+	// 192.168.1.1: true
+	// 8.8.8.8: false
+}
+
+// This example demonstrates checking whether addresses are in the shared
+// address space defined by RFC6598 that is commonly used for carrier-grade NAT.
+func ExampleIsRFC6598() {
+	for _, ip := range []string{"100.64.0.1", "100.128.0.1"} {
+		na := wire.NewNetAddressIPPort(net.ParseIP(ip), 9108, 0)
+		fmt.Printf("%s: %v\n", ip, addrmgr.IsRFC6598(na))
+	}
+
+	// Output:
+	// 100.64.0.1: true
+	// 100.128.0.1: false
+}
+
+// This example demonstrates checking whether addresses are in any of the
+// reserved address blocks and how that relates to whether they are routable.
+func ExampleIsReserved() {
+	ips := []string{"10.0.0.1", "203.0.113.1", "fe80::1", "8.8.8.8",
+		"2602:100::1"}
+	for _, ip := range ips {
+		na := wire.NewNetAddressIPPort(net.ParseIP(ip), 9108, 0)
+		fmt.Printf("%s: reserved %v, routable %v\n", ip,
+			addrmgr.IsReserved(na), addrmgr.IsRoutable(na))
+	}
+
+	// Output:
+	// 10.0.0.1: reserved true, routable false
+	// 203.0.113.1: reserved true, routable false
+	// fe80::1: reserved true, routable false
+	// 8.8.8.8: reserved false, routable true
+	// 2602:100::1: reserved false, routable true
+}
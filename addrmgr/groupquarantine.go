@@ -0,0 +1,65 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package addrmgr
+
+import (
+	"sync"
+	"time"
+
+	"github.com/decred/dcrd/wire"
+)
+
+// GroupQuarantine provides a concurrency safe set of network groups, as
+// determined by GroupKey, that should temporarily be avoided.  It is intended
+// to be used by callers that wish to stop connecting to all addresses in a
+// group that has repeatedly yielded misbehaving peers.
+type GroupQuarantine struct {
+	mtx    sync.Mutex
+	groups map[string]time.Time // group key to quarantine expiration
+}
+
+// NewGroupQuarantine returns a new empty group quarantine.
+func NewGroupQuarantine() *GroupQuarantine {
+	return &GroupQuarantine{
+		groups: make(map[string]time.Time),
+	}
+}
+
+// Add quarantines the provided network group until the provided time.  Adding
+// a group that is already quarantined replaces its expiration.  Any expired
+// quarantines are removed so groups that are never queried again do not
+// accumulate.
+func (q *GroupQuarantine) Add(group string, until time.Time) {
+	now := time.Now()
+
+	q.mtx.Lock()
+	for g, expires := range q.groups {
+		if !now.Before(expires) {
+			delete(q.groups, g)
+		}
+	}
+	q.groups[group] = until
+	q.mtx.Unlock()
+}
+
+// IsQuarantined returns whether or not the network group of the provided
+// address is currently quarantined.  Expired quarantines are removed as they
+// are encountered.
+func (q *GroupQuarantine) IsQuarantined(na *wire.NetAddress) bool {
+	group := GroupKey(na)
+
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	until, ok := q.groups[group]
+	if !ok {
+		return false
+	}
+	if !time.Now().Before(until) {
+		delete(q.groups, group)
+		return false
+	}
+	return true
+}
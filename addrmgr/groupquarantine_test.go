@@ -0,0 +1,65 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package addrmgr
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/wire"
+)
+
+// TestGroupQuarantine ensures addresses in a quarantined group are reported as
+// quarantined until the quarantine expires and that addresses in other groups
+// are unaffected.
+func TestGroupQuarantine(t *testing.T) {
+	newNetAddr := func(ip string) *wire.NetAddress {
+		return wire.NewNetAddressIPPort(net.ParseIP(ip), 8333,
+			wire.SFNodeNetwork)
+	}
+
+	q := NewGroupQuarantine()
+	quarantined := newNetAddr("12.1.2.3")
+	sameGroup := newNetAddr("12.1.200.100")
+	otherGroup := newNetAddr("173.1.2.3")
+	if q.IsQuarantined(quarantined) {
+		t.Fatal("address quarantined before its group was added")
+	}
+
+	// Ensure all addresses in the group are quarantined while other groups
+	// are not.
+	q.Add(GroupKey(quarantined), time.Now().Add(time.Hour))
+	if !q.IsQuarantined(quarantined) {
+		t.Fatal("address in quarantined group is not quarantined")
+	}
+	if !q.IsQuarantined(sameGroup) {
+		t.Fatal("other address in quarantined group is not quarantined")
+	}
+	if q.IsQuarantined(otherGroup) {
+		t.Fatal("address in different group is quarantined")
+	}
+
+	// Ensure an expired quarantine is lifted and removed.
+	q.Add(GroupKey(quarantined), time.Now().Add(-time.Second))
+	if q.IsQuarantined(quarantined) {
+		t.Fatal("address quarantined after expiration")
+	}
+	if _, ok := q.groups[GroupKey(quarantined)]; ok {
+		t.Fatal("expired quarantine was not removed")
+	}
+
+	// Ensure expired quarantines for groups that are never queried again
+	// are removed when another group is added.
+	q.Add(GroupKey(otherGroup), time.Now().Add(-time.Second))
+	q.Add(GroupKey(sameGroup), time.Now().Add(time.Hour))
+	if _, ok := q.groups[GroupKey(otherGroup)]; ok {
+		t.Fatal("expired quarantine was not pruned on add")
+	}
+	if len(q.groups) != 1 {
+		t.Fatalf("unexpected number of quarantined groups - got %d, want 1",
+			len(q.groups))
+	}
+}
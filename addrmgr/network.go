@@ -6,8 +6,12 @@
 package addrmgr
 
 import (
+	"encoding/binary"
 	"fmt"
 	"net"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/decred/dcrd/wire"
 )
@@ -85,6 +89,16 @@ var (
 	// { magic 6 bytes, 10 bytes base32 decode of key hash }
 	onionCatNet = ipNet("fd87:d87e:eb43::", 48, 128)
 
+	// cjdnsNet defines the IPv6 address block used by the CJDNS mesh network
+	// (FC00::/8).  This is the half of the RFC4193 unique local IPv6 range
+	// that does not contain the OnionCat range used to support Tor.
+	cjdnsNet = ipNet("FC00::", 8, 128)
+
+	// yggdrasilNet defines the IPv6 address block used by the Yggdrasil
+	// overlay network (0200::/7).  Node addresses are in 0200::/8 and the
+	// subnets routed to nodes are in 0300::/8.
+	yggdrasilNet = ipNet("200::", 7, 128)
+
 	// zero4Net defines the IPv4 address block for address staring with 0
 	// (0.0.0.0/8).
 	zero4Net = ipNet("0.0.0.0", 8, 32)
@@ -118,6 +132,20 @@ func isOnionCatTor(na *wire.NetAddress) bool {
 	return onionCatNet.Contains(na.IP)
 }
 
+// isCJDNS returns whether or not the passed address is in the IPv6 range used
+// by the CJDNS mesh network (FC00::/8).  Note that this range is part of the
+// RFC4193 unique local IPv6 range, but it does not include the OnionCat range
+// used to support Tor (fd87:d87e:eb43::/48).
+func isCJDNS(na *wire.NetAddress) bool {
+	return cjdnsNet.Contains(na.IP)
+}
+
+// isYggdrasil returns whether or not the passed address is in the IPv6 range
+// used by the Yggdrasil overlay network (0200::/7).
+func isYggdrasil(na *wire.NetAddress) bool {
+	return yggdrasilNet.Contains(na.IP)
+}
+
 // NetworkAddress type is used to classify a network address.
 type NetworkAddress int
 
@@ -128,8 +156,23 @@ const (
 	OnionAddress
 )
 
-// getNetwork returns the network address type of the provided network address.
-func getNetwork(na *wire.NetAddress) NetworkAddress {
+// String returns the NetworkAddress in human-readable form.
+func (t NetworkAddress) String() string {
+	switch t {
+	case LocalAddress:
+		return "local"
+	case IPv4Address:
+		return "ipv4"
+	case IPv6Address:
+		return "ipv6"
+	case OnionAddress:
+		return "onion"
+	}
+	return fmt.Sprintf("unknown NetworkAddress (%d)", int(t))
+}
+
+// AddressType returns the network address type of the provided network address.
+func AddressType(na *wire.NetAddress) NetworkAddress {
 	switch {
 	case isLocal(na):
 		return LocalAddress
@@ -145,10 +188,44 @@ func getNetwork(na *wire.NetAddress) NetworkAddress {
 	}
 }
 
-// isRFC1918 returns whether or not the passed address is part of the IPv4
+// TransportFor returns the name of the transport that must be used to dial an
+// address of the provided network address type.  Local, IPv4, and IPv6
+// addresses are reached over a direct "clearnet" connection while onion
+// addresses must be reached via a Tor proxy.  An error is returned for unknown
+// network address types.
+func TransportFor(t NetworkAddress) (string, error) {
+	switch t {
+	case LocalAddress, IPv4Address, IPv6Address:
+		return "clearnet", nil
+
+	case OnionAddress:
+		return "tor", nil
+	}
+
+	return "", fmt.Errorf("unknown network address type %d", t)
+}
+
+// ReachableTypes returns the network address types the local node is able to
+// dial given which transports it has enabled.  Local addresses are not
+// included since they are never routable.
+func ReachableTypes(haveIPv4, haveIPv6, haveTor bool) []NetworkAddress {
+	var types []NetworkAddress
+	if haveIPv4 {
+		types = append(types, IPv4Address)
+	}
+	if haveIPv6 {
+		types = append(types, IPv6Address)
+	}
+	if haveTor {
+		types = append(types, OnionAddress)
+	}
+	return types
+}
+
+// IsRFC1918 returns whether or not the passed address is part of the IPv4
 // private network address space as defined by RFC1918 (10.0.0.0/8,
 // 172.16.0.0/12, or 192.168.0.0/16).
-func isRFC1918(na *wire.NetAddress) bool {
+func IsRFC1918(na *wire.NetAddress) bool {
 	for _, rfc := range rfc1918Nets {
 		if rfc.Contains(na.IP) {
 			return true
@@ -157,6 +234,11 @@ func isRFC1918(na *wire.NetAddress) bool {
 	return false
 }
 
+// isRFC1918 is an unexported alias for IsRFC1918 used by internal call sites.
+func isRFC1918(na *wire.NetAddress) bool {
+	return IsRFC1918(na)
+}
+
 // isRFC2544 returns whether or not the passed address is part of the IPv4
 // address space as defined by RFC2544 (198.18.0.0/15)
 func isRFC2544(na *wire.NetAddress) bool {
@@ -230,12 +312,25 @@ func isRFC6145(na *wire.NetAddress) bool {
 	return rfc6145Net.Contains(na.IP)
 }
 
-// isRFC6598 returns whether or not the passed address is part of the IPv4
-// shared address space specified by RFC6598 (100.64.0.0/10)
-func isRFC6598(na *wire.NetAddress) bool {
+// IsRFC6598 returns whether or not the passed address is part of the IPv4
+// shared address space specified by RFC6598 (100.64.0.0/10).
+func IsRFC6598(na *wire.NetAddress) bool {
 	return rfc6598Net.Contains(na.IP)
 }
 
+// isRFC6598 is an unexported alias for IsRFC6598 used by internal call sites.
+func isRFC6598(na *wire.NetAddress) bool {
+	return IsRFC6598(na)
+}
+
+// isNAT64 returns whether or not the passed address is an IPv6 address that
+// embeds an IPv4 address in its last four bytes by way of either the IPv6
+// well-known prefix range as defined by RFC6052 (64:FF9B::/96) or the IPv6 to
+// IPv4 translated address range as defined by RFC6145 (::FFFF:0:0:0/96).
+func isNAT64(na *wire.NetAddress) bool {
+	return isRFC6052(na) || isRFC6145(na)
+}
+
 // isValid returns whether or not the passed address is valid.  The address is
 // considered invalid under the following circumstances:
 // IPv4: It is either a zero or all bits set address.
@@ -247,62 +342,396 @@ func isValid(na *wire.NetAddress) bool {
 		na.IP.Equal(net.IPv4bcast))
 }
 
+// reservedRanges associates each of the reserved address predicates with a
+// human-readable description of the range.  It is the source of the ranges
+// checked by IsReserved and described by RoutabilityReport.
+var reservedRanges = []struct {
+	contains    func(*wire.NetAddress) bool
+	description string
+}{
+	{isLocal, "local"},
+	{isRFC1918, "RFC1918 private"},
+	{isRFC2544, "RFC2544 benchmarking"},
+	{isRFC3927, "RFC3927 link-local"},
+	{isRFC4862, "RFC4862 link-local"},
+	{isRFC3849, "RFC3849 documentation"},
+	{isRFC4843, "RFC4843 ORCHID"},
+	{isRFC5737, "RFC5737 documentation"},
+	{isRFC6598, "RFC6598 shared address space"},
+	{isRFC4193, "RFC4193 unique local"},
+}
+
+// IsReserved returns whether or not the passed address is in any of the
+// reserved address blocks that are not routable over the public internet.  This
+// includes the private, shared, documentation, benchmarking, link-local,
+// ORCHID, and local ranges, as well as the RFC4193 unique local range aside from
+// the part of it used to support Tor.
+//
+// NAT64 translated addresses are reserved when the IPv4 address they embed is
+// either invalid or reserved.  Yggdrasil overlay addresses are not reserved.
+//
+// An address is routable, as reported by IsRoutable, if and only if it is valid
+// and not reserved.
+func IsReserved(na *wire.NetAddress) bool {
+	if isNAT64(na) {
+		ip := na.IP[12:16]
+		embedded := wire.NetAddress{IP: net.IPv4(ip[0], ip[1], ip[2], ip[3])}
+		return !isValid(&embedded) || IsReserved(&embedded)
+	}
+	if isYggdrasil(na) || isOnionCatTor(na) {
+		return false
+	}
+
+	for _, reserved := range reservedRanges {
+		if reserved.contains(na) {
+			return true
+		}
+	}
+	return false
+}
+
 // IsRoutable returns whether or not the passed address is routable over
 // the public internet.  This is true as long as the address is valid and is not
-// in any reserved ranges.
+// in any reserved ranges as reported by IsReserved.  The routability of NAT64
+// translated addresses is determined by the IPv4 address they embed.
+// Yggdrasil overlay addresses are considered routable.
 func IsRoutable(na *wire.NetAddress) bool {
-	return isValid(na) && !(isRFC1918(na) || isRFC2544(na) ||
-		isRFC3927(na) || isRFC4862(na) || isRFC3849(na) ||
-		isRFC4843(na) || isRFC5737(na) || isRFC6598(na) ||
-		isLocal(na) || (isRFC4193(na) && !isOnionCatTor(na)))
+	return strictRoutability.IsRoutable(na)
+}
+
+// isPrivate returns whether or not the passed address is part of one of the
+// private address blocks which are commonly used for local networks.  This
+// consists of the RFC1918 and RFC6598 IPv4 blocks along with the RFC4193 IPv6
+// unique local block.
+func isPrivate(na *wire.NetAddress) bool {
+	return isRFC1918(na) || isRFC6598(na) || isRFC4193(na)
+}
+
+// RoutabilityChecker determines whether or not addresses are routable with
+// optional relaxations of the policy used by IsRoutable.  The zero value applies
+// the same policy as IsRoutable.
+type RoutabilityChecker struct {
+	// AllowCJDNS treats addresses in the CJDNS range (FC00::/8) as routable.
+	// They are otherwise considered unroutable since they are part of the
+	// RFC4193 unique local range.
+	AllowCJDNS bool
+
+	// AllowPrivate treats addresses in the private RFC1918, RFC6598, and
+	// RFC4193 ranges as routable.  This is primarily useful for test
+	// networks such as simnet where peers are intentionally run on local
+	// networks.  Invalid addresses, such as the unspecified and broadcast
+	// addresses, are still considered unroutable.
+	AllowPrivate bool
+}
+
+// strictRoutability is the routability checker used by IsRoutable.  It does
+// not relax any of the policy.
+var strictRoutability RoutabilityChecker
+
+// IsRoutable returns whether or not the passed address is routable according
+// to the policy of the checker.
+func (c *RoutabilityChecker) IsRoutable(na *wire.NetAddress) bool {
+	if !isValid(na) {
+		return false
+	}
+	if c.AllowCJDNS && isCJDNS(na) {
+		return true
+	}
+	if c.AllowPrivate && isPrivate(na) {
+		return true
+	}
+	return !IsReserved(na)
+}
+
+// RoutabilityReport returns a human-readable description of how the passed
+// address is classified for the purposes of routability.  Routable addresses
+// are described by their network, such as "routable IPv4" or "Tor
+// (OnionCat)", while unroutable addresses list the reason they are not
+// routable, such as "unroutable: RFC1918 private".  The verdict always agrees
+// with IsRoutable.
+func RoutabilityReport(na *wire.NetAddress) string {
+	switch {
+	case na.IP == nil:
+		return "unroutable: invalid"
+	case na.IP.IsUnspecified():
+		return "unroutable: unspecified"
+	case na.IP.Equal(net.IPv4bcast):
+		return "unroutable: broadcast"
+	case isOnionCatTor(na):
+		return "Tor (OnionCat)"
+	case isYggdrasil(na):
+		return "routable Yggdrasil"
+	case isNAT64(na):
+		ip := na.IP[12:16]
+		embedded := wire.NetAddress{IP: net.IPv4(ip[0], ip[1], ip[2], ip[3])}
+		if !IsRoutable(&embedded) {
+			report := RoutabilityReport(&embedded)
+			return "unroutable: NAT64 embedding " +
+				strings.TrimPrefix(report, "unroutable: ")
+		}
+		return "routable IPv6 (NAT64)"
+	}
+
+	var reasons []string
+	for _, reserved := range reservedRanges {
+		if reserved.contains(na) {
+			reasons = append(reasons, reserved.description)
+		}
+	}
+	if isCJDNS(na) {
+		reasons = append(reasons, "CJDNS")
+	}
+	if len(reasons) > 0 {
+		return "unroutable: " + strings.Join(reasons, ", ")
+	}
+
+	if isIPv4(na) {
+		return "routable IPv4"
+	}
+	return "routable IPv6"
 }
 
 // GroupKey returns a string representing the network group an address is part
 // of.  This is the /16 for IPv4, the /32 (/36 for he.net) for IPv6, the string
 // "local" for a local address, the string "tor:key" where key is the /4 of the
-// onion address for Tor address, and the string "unroutable" for an unroutable
-// address.
+// onion address for Tor address, the string "yggdrasil:prefix" where prefix is
+// the /32 of the address for a Yggdrasil address, and the string "unroutable"
+// for an unroutable address.  CJDNS addresses are unroutable by default and
+// therefore are grouped as such.  See RoutabilityChecker.GroupKey for grouping
+// them separately.
 func GroupKey(na *wire.NetAddress) string {
+	return strictRoutability.GroupKey(na)
+}
+
+// GroupKey returns a string representing the network group an address is part
+// of according to the policy of the checker.  It is the same as the package
+// level GroupKey except that addresses are only grouped as "unroutable" when
+// they are not routable according to the checker.  When CJDNS addresses are
+// allowed, they are grouped by the string "cjdns:prefix" where prefix is the
+// /16 of the address.
+func (c *RoutabilityChecker) GroupKey(na *wire.NetAddress) string {
 	if isLocal(na) {
 		return "local"
 	}
-	if !IsRoutable(na) {
+	if c.AllowCJDNS && isCJDNS(na) {
+		// CJDNS addresses are grouped by the /16 of the overlay
+		// address.
+		return "cjdns:" + na.IP.Mask(net.CIDRMask(16, 128)).String()
+	}
+	if !c.IsRoutable(na) {
 		return "unroutable"
 	}
 	if isIPv4(na) {
-		return na.IP.Mask(net.CIDRMask(16, 32)).String()
+		ip := na.IP.To4()
+		return ipv4GroupKey(ip[0], ip[1])
 	}
 	if isRFC6145(na) || isRFC6052(na) {
 		// last four bytes are the ip address
-		ip := na.IP[12:16]
-		return ip.Mask(net.CIDRMask(16, 32)).String()
+		return ipv4GroupKey(na.IP[12], na.IP[13])
 	}
 
 	if isRFC3964(na) {
-		ip := na.IP[2:6]
-		return ip.Mask(net.CIDRMask(16, 32)).String()
+		return ipv4GroupKey(na.IP[2], na.IP[3])
 	}
 	if isRFC4380(na) {
 		// teredo tunnels have the last 4 bytes as the v4 address XOR
 		// 0xff.
-		ip := net.IP(make([]byte, 4))
-		for i, byte := range na.IP[12:16] {
-			ip[i] = byte ^ 0xff
-		}
-		return ip.Mask(net.CIDRMask(16, 32)).String()
+		return ipv4GroupKey(na.IP[12]^0xff, na.IP[13]^0xff)
 	}
 	if isOnionCatTor(na) {
 		// group is keyed off the first 4 bits of the actual onion key.
-		return fmt.Sprintf("tor:%d", na.IP[6]&((1<<4)-1))
+		return "tor:" + strconv.Itoa(int(na.IP[6]&((1<<4)-1)))
+	}
+	if isYggdrasil(na) {
+		// Yggdrasil addresses are grouped separately from the rest of the
+		// IPv6 address space by the /32 of the overlay address.
+		return "yggdrasil:" + na.IP.Mask(net.CIDRMask(32, 128)).String()
 	}
 
 	// OK, so now we know ourselves to be a IPv6 address.
 	// bitcoind uses /32 for everything, except for Hurricane Electric's
 	// (he.net) IP range, which it uses /36 for.
-	bits := 32
-	if heNet.Contains(na.IP) {
-		bits = 36
+	ip := na.IP.To16()
+	groups := [3]uint16{
+		uint16(ip[0])<<8 | uint16(ip[1]),
+		uint16(ip[2])<<8 | uint16(ip[3]),
+	}
+	if heNet.Contains(ip) {
+		groups[2] = uint16(ip[4]&0xf0) << 8
+	}
+	return ipv6GroupKey(groups)
+}
+
+// ipv4GroupKey returns the group key for the /16 IPv4 network that starts with
+// the provided two octets.  It produces the same result as calling String on
+// the masked net.IP without the intermediate allocations.
+func ipv4GroupKey(a, b byte) string {
+	var buf [15]byte
+	key := strconv.AppendUint(buf[:0], uint64(a), 10)
+	key = append(key, '.')
+	key = strconv.AppendUint(key, uint64(b), 10)
+	key = append(key, ".0.0"...)
+	return string(key)
+}
+
+// ipv6GroupKey returns the group key for the IPv6 network that starts with the
+// provided 16-bit groups and has all remaining groups set to zero.  It produces
+// the same result as calling String on the masked net.IP without the
+// intermediate allocations.
+//
+// Since at most the first three groups are nonzero, the run of trailing zero
+// groups is always the longest one and therefore is the one that is
+// compressed.
+func ipv6GroupKey(groups [3]uint16) string {
+	last := -1
+	for i, group := range groups {
+		if group != 0 {
+			last = i
+		}
+	}
+
+	var buf [16]byte
+	key := buf[:0]
+	for i := 0; i <= last; i++ {
+		if i > 0 {
+			key = append(key, ':')
+		}
+		key = strconv.AppendUint(key, uint64(groups[i]), 16)
+	}
+	key = append(key, "::"...)
+	return string(key)
+}
+
+// LikelySamePeerGroup returns whether or not the provided addresses are part of
+// the same network group as determined by GroupKey, such as the same /16 for
+// IPv4 or the same /4 of the onion key for Tor.  Addresses in the same group
+// are likely controlled by the same operator and are therefore treated as the
+// same peer for the purposes of connection diversity.
+//
+// Note that all unroutable addresses share a single group and local addresses
+// share another, so such addresses are always considered part of the same
+// group as one another.
+func LikelySamePeerGroup(a, b *wire.NetAddress) bool {
+	return GroupKey(a) == GroupKey(b)
+}
+
+// NewGroups returns the network group keys, as determined by GroupKey, of the
+// addresses in incoming that are not present in any of the addresses in
+// existing.  Each group key is only returned once and the keys are in the order
+// they are first encountered in incoming.
+func NewGroups(existing, incoming []*wire.NetAddress) []string {
+	seen := make(map[string]struct{}, len(existing))
+	for _, na := range existing {
+		seen[GroupKey(na)] = struct{}{}
 	}
 
-	return na.IP.Mask(net.CIDRMask(bits, 128)).String()
+	var groups []string
+	for _, na := range incoming {
+		key := GroupKey(na)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		groups = append(groups, key)
+	}
+	return groups
+}
+
+// ConnectivityDiversity returns the number of distinct network groups, as
+// determined by GroupKey, the provided addresses belong to.  For example, Tor
+// addresses that share the same group key are only counted once.
+func ConnectivityDiversity(addrs []*wire.NetAddress) int {
+	groups := make(map[string]struct{}, len(addrs))
+	for _, na := range addrs {
+		groups[GroupKey(na)] = struct{}{}
+	}
+	return len(groups)
+}
+
+// SameSubnet returns whether or not the provided addresses share the same
+// network prefix of the given length in bits.  This is a finer grained check
+// than GroupKey and is intended for local peer discovery.  Addresses of
+// different families and prefix lengths that are out of range for the family
+// of the addresses are never considered to be in the same subnet.
+func SameSubnet(a, b *wire.NetAddress, prefixLen int) bool {
+	if a.IP == nil || b.IP == nil || isIPv4(a) != isIPv4(b) {
+		return false
+	}
+
+	ipA, ipB, bits := a.IP.To16(), b.IP.To16(), 128
+	if isIPv4(a) {
+		ipA, ipB, bits = a.IP.To4(), b.IP.To4(), 32
+	}
+	if prefixLen < 0 || prefixLen > bits {
+		return false
+	}
+	mask := net.CIDRMask(prefixLen, bits)
+	return ipA.Mask(mask).Equal(ipB.Mask(mask))
+}
+
+// CoalesceIPv4 merges the provided IPv4 addresses into the smallest set of
+// network prefixes which cover exactly those addresses.  For example, the
+// addresses 10.0.0.0 through 10.0.0.255 coalesce into 10.0.0.0/24 while
+// addresses that do not share a prefix with any others remain as /32s.  This is
+// primarily useful for compactly logging long lists of addresses.
+//
+// Duplicate addresses are only counted once and addresses which are not IPv4
+// are ignored.  The returned prefixes are sorted in ascending order.
+func CoalesceIPv4(addrs []net.IP) []net.IPNet {
+	ips := make([]uint32, 0, len(addrs))
+	for _, addr := range addrs {
+		ip := addr.To4()
+		if ip == nil {
+			continue
+		}
+		ips = append(ips, binary.BigEndian.Uint32(ip))
+	}
+	if len(ips) == 0 {
+		return nil
+	}
+	sort.Slice(ips, func(i, j int) bool { return ips[i] < ips[j] })
+
+	// Split the sorted addresses into runs of contiguous addresses and
+	// convert each run into the minimal set of aligned prefixes that covers
+	// it.  The run boundaries are tracked as 64-bit values to avoid
+	// overflow at the top of the address space.
+	var prefixes []net.IPNet
+	addRange := func(start, end uint64) {
+		for start <= end {
+			// Find the largest block that is aligned to the start of
+			// the range and does not extend past the end of it.
+			size := uint64(1)
+			for start&(size<<1-1) == 0 && start+size<<1-1 <= end &&
+				size < 1<<32 {
+
+				size <<= 1
+			}
+
+			ip := make(net.IP, net.IPv4len)
+			binary.BigEndian.PutUint32(ip, uint32(start))
+			ones := 32
+			for s := size; s > 1; s >>= 1 {
+				ones--
+			}
+			prefixes = append(prefixes, net.IPNet{
+				IP:   ip,
+				Mask: net.CIDRMask(ones, 32),
+			})
+			start += size
+		}
+	}
+	start, end := uint64(ips[0]), uint64(ips[0])
+	for _, ip := range ips[1:] {
+		switch {
+		case uint64(ip) == end:
+			continue
+		case uint64(ip) == end+1:
+			end++
+			continue
+		}
+		addRange(start, end)
+		start, end = uint64(ip), uint64(ip)
+	}
+	addRange(start, end)
+	return prefixes
 }
@@ -7,6 +7,8 @@ package addrmgr
 
 import (
 	"net"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/decred/dcrd/wire"
@@ -73,7 +75,7 @@ func TestIPTypes(t *testing.T) {
 		newIPTest("fe80:1::1", false, false, false, false, false, false,
 			false, false, false, false, false, false, false, false, true, true),
 		newIPTest("64:ff9b::1", false, false, false, false, false, false,
-			false, false, false, false, true, false, false, false, true, true),
+			false, false, false, false, true, false, false, false, true, false),
 		newIPTest("::ffff:abcd:ef12:1", false, false, false, false, false, false,
 			false, false, false, false, false, false, false, false, true, true),
 		newIPTest("::1", false, false, false, false, false, false, false, false,
@@ -142,6 +144,196 @@ func TestIPTypes(t *testing.T) {
 	}
 }
 
+// TestExportedRangePredicates ensures the exported range predicates agree with
+// their unexported counterparts and that IsReserved and IsRoutable remain
+// logically consistent.
+func TestExportedRangePredicates(t *testing.T) {
+	tests := []struct {
+		ip       string
+		rfc1918  bool
+		rfc6598  bool
+		reserved bool
+	}{
+		{ip: "10.255.255.255", rfc1918: true, reserved: true},
+		{ip: "172.16.0.1", rfc1918: true, reserved: true},
+		{ip: "192.168.0.1", rfc1918: true, reserved: true},
+		{ip: "100.64.0.1", rfc6598: true, reserved: true},
+		{ip: "100.127.255.1", rfc6598: true, reserved: true},
+		{ip: "100.128.0.1", reserved: false},
+		{ip: "169.254.250.120", reserved: true},
+		{ip: "198.18.0.1", reserved: true},
+		{ip: "203.0.113.1", reserved: true},
+		{ip: "127.0.0.1", reserved: true},
+		{ip: "0.0.0.0", reserved: true},
+		{ip: "255.255.255.255", reserved: false},
+		{ip: "12.1.2.3", reserved: false},
+		{ip: "::1", reserved: true},
+		{ip: "2001:db8::1", reserved: true},
+		{ip: "2001:10:abcd::1:1", reserved: true},
+		{ip: "fe80::1", reserved: true},
+		{ip: "fd00:dead::1", reserved: true},
+		{ip: "fc12::1", reserved: true},
+		{ip: "fd87:d87e:eb43:1234::5678", reserved: false},
+		{ip: "64:ff9b::0c01:0203", reserved: false},
+		{ip: "64:ff9b::0a00:0001", reserved: true},
+		{ip: "64:ff9b::ffff:ffff", reserved: true},
+		{ip: "200:1234::1", reserved: false},
+		{ip: "2602:100::1", reserved: false},
+	}
+
+	for _, test := range tests {
+		na := wire.NewNetAddressIPPort(net.ParseIP(test.ip), 8333,
+			wire.SFNodeNetwork)
+		if got := IsRFC1918(na); got != test.rfc1918 || got != isRFC1918(na) {
+			t.Errorf("IsRFC1918 %s\n got: %v want: %v", test.ip, got,
+				test.rfc1918)
+		}
+		if got := IsRFC6598(na); got != test.rfc6598 || got != isRFC6598(na) {
+			t.Errorf("IsRFC6598 %s\n got: %v want: %v", test.ip, got,
+				test.rfc6598)
+		}
+		if got := IsReserved(na); got != test.reserved {
+			t.Errorf("IsReserved %s\n got: %v want: %v", test.ip, got,
+				test.reserved)
+		}
+		wantRoutable := isValid(na) && !IsReserved(na)
+		if got := IsRoutable(na); got != wantRoutable {
+			t.Errorf("IsRoutable %s is inconsistent with IsReserved\n "+
+				"got: %v want: %v", test.ip, got, wantRoutable)
+		}
+	}
+}
+
+// TestYggdrasil ensures Yggdrasil overlay addresses are detected and considered
+// routable without capturing the neighboring ranges or the RFC4193 unique local
+// range that contains the OnionCat Tor range.
+func TestYggdrasil(t *testing.T) {
+	tests := []struct {
+		name      string
+		ip        string
+		yggdrasil bool
+		routable  bool
+	}{
+		{name: "start of node range", ip: "200::", yggdrasil: true, routable: true},
+		{name: "node address", ip: "200:1234:5678::1", yggdrasil: true, routable: true},
+		{name: "start of subnet range", ip: "300::", yggdrasil: true, routable: true},
+		{name: "end of subnet range", ip: "3ff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", yggdrasil: true, routable: true},
+		{name: "just below range", ip: "1ff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", yggdrasil: false, routable: true},
+		{name: "just above range", ip: "400::", yggdrasil: false, routable: true},
+		{name: "rfc4193 unique local", ip: "fd00:dead::1", yggdrasil: false, routable: false},
+		{name: "rfc4193 start", ip: "fc00::1", yggdrasil: false, routable: false},
+		{name: "onioncat tor", ip: "fd87:d87e:eb43:1234::5678", yggdrasil: false, routable: true},
+		{name: "ipv4", ip: "12.1.2.3", yggdrasil: false, routable: true},
+	}
+
+	for _, test := range tests {
+		na := wire.NewNetAddressIPPort(net.ParseIP(test.ip), 8333,
+			wire.SFNodeNetwork)
+		if got := isYggdrasil(na); got != test.yggdrasil {
+			t.Errorf("%q: unexpected isYggdrasil result - got %v, want %v",
+				test.name, got, test.yggdrasil)
+		}
+		if got := IsRoutable(na); got != test.routable {
+			t.Errorf("%q: unexpected IsRoutable result - got %v, want %v",
+				test.name, got, test.routable)
+		}
+	}
+}
+
+// TestCJDNS ensures CJDNS addresses are distinguished from the OnionCat Tor
+// range and the rest of the RFC4193 unique local range and that they are only
+// considered routable when explicitly allowed.
+func TestCJDNS(t *testing.T) {
+	tests := []struct {
+		name       string
+		ip         string
+		cjdns      bool
+		onionCat   bool
+		rfc4193    bool
+		routable   bool
+		allowCJDNS bool // routable when cjdns is allowed
+	}{
+		{name: "cjdns start of range", ip: "fc00::", cjdns: true,
+			rfc4193: true, allowCJDNS: true},
+		{name: "cjdns", ip: "fc12:3456::1", cjdns: true, rfc4193: true,
+			allowCJDNS: true},
+		{name: "cjdns end of range", ip: "fcff:ffff:ffff:ffff:ffff:ffff:ffff:ffff",
+			cjdns: true, rfc4193: true, allowCJDNS: true},
+		{name: "generic ula", ip: "fd00:dead::1", rfc4193: true},
+		{name: "ula start of fd00::/8", ip: "fd00::", rfc4193: true},
+		{name: "onioncat tor", ip: "fd87:d87e:eb43:1234::5678", onionCat: true,
+			rfc4193: true, routable: true, allowCJDNS: true},
+		{name: "below rfc4193", ip: "fbff:ffff::1", routable: true,
+			allowCJDNS: true},
+		{name: "ipv4", ip: "12.1.2.3", routable: true, allowCJDNS: true},
+	}
+
+	allowCJDNS := RoutabilityChecker{AllowCJDNS: true}
+	var strict RoutabilityChecker
+	for _, test := range tests {
+		na := wire.NewNetAddressIPPort(net.ParseIP(test.ip), 8333,
+			wire.SFNodeNetwork)
+		if got := isCJDNS(na); got != test.cjdns {
+			t.Errorf("%q: unexpected isCJDNS result - got %v, want %v",
+				test.name, got, test.cjdns)
+		}
+		if got := isOnionCatTor(na); got != test.onionCat {
+			t.Errorf("%q: unexpected isOnionCatTor result - got %v, want %v",
+				test.name, got, test.onionCat)
+		}
+		if got := isRFC4193(na); got != test.rfc4193 {
+			t.Errorf("%q: unexpected isRFC4193 result - got %v, want %v",
+				test.name, got, test.rfc4193)
+		}
+		if got := IsRoutable(na); got != test.routable {
+			t.Errorf("%q: unexpected IsRoutable result - got %v, want %v",
+				test.name, got, test.routable)
+		}
+		if got := strict.IsRoutable(na); got != test.routable {
+			t.Errorf("%q: unexpected strict IsRoutable result - got %v, "+
+				"want %v", test.name, got, test.routable)
+		}
+		if got := allowCJDNS.IsRoutable(na); got != test.allowCJDNS {
+			t.Errorf("%q: unexpected IsRoutable result with cjdns "+
+				"allowed - got %v, want %v", test.name, got,
+				test.allowCJDNS)
+		}
+	}
+}
+
+// TestNAT64Routable ensures the routability of NAT64 translated addresses is
+// determined by the IPv4 address they embed.
+func TestNAT64Routable(t *testing.T) {
+	tests := []struct {
+		name     string
+		ip       string
+		nat64    bool
+		routable bool
+	}{
+		{name: "rfc6052 routable ipv4", ip: "64:ff9b::0c01:0203", nat64: true, routable: true},
+		{name: "rfc6052 rfc1918 ipv4", ip: "64:ff9b::0a00:0001", nat64: true, routable: false},
+		{name: "rfc6052 rfc5737 ipv4", ip: "64:ff9b::c000:0201", nat64: true, routable: false},
+		{name: "rfc6052 bcast ipv4", ip: "64:ff9b::ffff:ffff", nat64: true, routable: false},
+		{name: "rfc6145 routable ipv4", ip: "::ffff:0:0c01:0203", nat64: true, routable: true},
+		{name: "rfc6145 rfc1918 ipv4", ip: "::ffff:0:c0a8:0101", nat64: true, routable: false},
+		{name: "ipv4", ip: "12.1.2.3", nat64: false, routable: true},
+		{name: "ipv6", ip: "2602:100::1", nat64: false, routable: true},
+	}
+
+	for _, test := range tests {
+		na := wire.NewNetAddressIPPort(net.ParseIP(test.ip), 8333,
+			wire.SFNodeNetwork)
+		if got := isNAT64(na); got != test.nat64 {
+			t.Errorf("%q: unexpected isNAT64 result - got %v, want %v",
+				test.name, got, test.nat64)
+		}
+		if got := IsRoutable(na); got != test.routable {
+			t.Errorf("%q: unexpected IsRoutable result - got %v, want %v",
+				test.name, got, test.routable)
+		}
+	}
+}
+
 // TestGroupKey tests the GroupKey function to ensure it properly groups various
 // IP addresses.
 func TestGroupKey(t *testing.T) {
@@ -164,6 +356,7 @@ func TestGroupKey(t *testing.T) {
 		{name: "ipv6 rfc3849 2001:db8::/32", ip: "2001:db8::1234", expected: "unroutable"},
 		{name: "ipv4 rfc3927 169.254/16", ip: "169.254.1.2", expected: "unroutable"},
 		{name: "ipv6 rfc4193 fc00::/7", ip: "fc00::1234", expected: "unroutable"},
+		{name: "ipv6 rfc4193 fd00::/8", ip: "fd00::1234", expected: "unroutable"},
 		{name: "ipv6 rfc4843 2001:10::/28", ip: "2001:10::1234", expected: "unroutable"},
 		{name: "ipv6 rfc4862 fe80::/64", ip: "fe80::1234", expected: "unroutable"},
 
@@ -171,6 +364,8 @@ func TestGroupKey(t *testing.T) {
 		{name: "ipv4 normal class a", ip: "12.1.2.3", expected: "12.1.0.0"},
 		{name: "ipv4 normal class b", ip: "173.1.2.3", expected: "173.1.0.0"},
 		{name: "ipv4 normal class c", ip: "196.1.2.3", expected: "196.1.0.0"},
+		{name: "ipv4 second octet zero", ip: "196.0.2.3", expected: "196.0.0.0"},
+		{name: "ipv4 max octets", ip: "223.255.2.3", expected: "223.255.0.0"},
 
 		// IPv6/IPv4 translations.
 		{name: "ipv6 rfc3964 with ipv4 encap", ip: "2002:0c01:0203::", expected: "12.1.0.0"},
@@ -183,11 +378,26 @@ func TestGroupKey(t *testing.T) {
 		{name: "ipv6 tor onioncat 2", ip: "fd87:d87e:eb43:1245::6789", expected: "tor:2"},
 		{name: "ipv6 tor onioncat 3", ip: "fd87:d87e:eb43:1345::6789", expected: "tor:3"},
 
+		// CJDNS is unroutable by default.
+		{name: "cjdns", ip: "fc12:3456::1", expected: "unroutable"},
+		{name: "cjdns end of range", ip: "fcff:ffff::1", expected: "unroutable"},
+
+		// Yggdrasil.
+		{name: "yggdrasil node", ip: "200:1234:5678::1", expected: "yggdrasil:200:1234::"},
+		{name: "yggdrasil node 2", ip: "201:abcd:1:2::3", expected: "yggdrasil:201:abcd::"},
+		{name: "yggdrasil subnet", ip: "300:1234:5678:9abc::1", expected: "yggdrasil:300:1234::"},
+		{name: "yggdrasil top of range", ip: "3ff:ffff:ffff::1", expected: "yggdrasil:3ff:ffff::"},
+		{name: "below yggdrasil", ip: "1ff:ffff::1", expected: "1ff:ffff::"},
+		{name: "above yggdrasil", ip: "400:1234::1", expected: "400:1234::"},
+
 		// IPv6 normal.
 		{name: "ipv6 normal", ip: "2602:100::1", expected: "2602:100::"},
 		{name: "ipv6 normal 2", ip: "2602:0100::1234", expected: "2602:100::"},
 		{name: "ipv6 hurricane electric", ip: "2001:470:1f10:a1::2", expected: "2001:470:1000::"},
 		{name: "ipv6 hurricane electric 2", ip: "2001:0470:1f10:a1::2", expected: "2001:470:1000::"},
+		{name: "ipv6 hurricane electric low nibble", ip: "2001:470:f10:a1::2", expected: "2001:470::"},
+		{name: "ipv6 second group zero", ip: "2602::1", expected: "2602::"},
+		{name: "ipv6 max groups", ip: "2fff:ffff:ffff::1", expected: "2fff:ffff::"},
 	}
 
 	for i, test := range tests {
@@ -200,3 +410,535 @@ func TestGroupKey(t *testing.T) {
 		}
 	}
 }
+
+// TestRoutabilityCheckerGroupKey ensures the group keys produced by routability
+// checkers reflect their policy.
+func TestRoutabilityCheckerGroupKey(t *testing.T) {
+	allowCJDNS := &RoutabilityChecker{AllowCJDNS: true}
+	allowPrivate := &RoutabilityChecker{AllowPrivate: true}
+	tests := []struct {
+		name    string
+		checker *RoutabilityChecker
+		ip      string
+		want    string
+	}{
+		{"strict cjdns", &strictRoutability, "fc12:3456::1", "unroutable"},
+		{"strict rfc1918", &strictRoutability, "10.1.2.3", "unroutable"},
+		{"strict ipv4", &strictRoutability, "12.1.2.3", "12.1.0.0"},
+		{"cjdns", allowCJDNS, "fc12:3456::1", "cjdns:fc12::"},
+		{"cjdns 2", allowCJDNS, "fc12:7890::1", "cjdns:fc12::"},
+		{"cjdns start of range", allowCJDNS, "fc00::1234", "cjdns:fc00::"},
+		{"cjdns end of range", allowCJDNS, "fcff:ffff::1", "cjdns:fcff::"},
+		{"cjdns allowed rfc4193", allowCJDNS, "fd00::1234", "unroutable"},
+		{"cjdns allowed rfc1918", allowCJDNS, "10.1.2.3", "unroutable"},
+		{"cjdns allowed tor", allowCJDNS, "fd87:d87e:eb43:1234::5678", "tor:2"},
+		{"private rfc1918", allowPrivate, "10.1.2.3", "10.1.0.0"},
+		{"private rfc6598", allowPrivate, "100.64.1.2", "100.64.0.0"},
+		{"private rfc4193", allowPrivate, "fd00::1234", "fd00::"},
+		{"private localhost", allowPrivate, "127.0.0.1", "local"},
+		{"private bcast", allowPrivate, "255.255.255.255", "unroutable"},
+		{"private rfc5737", allowPrivate, "192.0.2.1", "unroutable"},
+	}
+
+	for _, test := range tests {
+		na := wire.NewNetAddressIPPort(net.ParseIP(test.ip), 8333,
+			wire.SFNodeNetwork)
+		if got := test.checker.GroupKey(na); got != test.want {
+			t.Errorf("%q: unexpected group key - got %v, want %v",
+				test.name, got, test.want)
+		}
+	}
+}
+
+// BenchmarkGroupKey benchmarks calculating the group key for various types of
+// addresses.
+func BenchmarkGroupKey(b *testing.B) {
+	benches := []struct {
+		name string
+		ip   string
+	}{
+		{name: "ipv4", ip: "173.194.115.66"},
+		{name: "ipv6", ip: "2602:100::1"},
+		{name: "ipv6 hurricane electric", ip: "2001:470:1f10:a1::2"},
+		{name: "teredo", ip: "2001:0:1234::f3fe:fdfc"},
+		{name: "tor", ip: "fd87:d87e:eb43:1234::5678"},
+	}
+
+	for _, bench := range benches {
+		na := wire.NewNetAddressIPPort(net.ParseIP(bench.ip), 8333,
+			wire.SFNodeNetwork)
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_ = GroupKey(na)
+			}
+		})
+	}
+}
+
+// TestAllowPrivate ensures private addresses are only considered routable when
+// the routability checker allows them and that invalid and other reserved
+// addresses remain unroutable regardless.
+func TestAllowPrivate(t *testing.T) {
+	tests := []struct {
+		name         string
+		ip           string
+		routable     bool
+		allowPrivate bool // routable when private addresses are allowed
+	}{
+		{name: "rfc1918 10/8", ip: "10.1.2.3", allowPrivate: true},
+		{name: "rfc1918 172.16/12", ip: "172.16.1.2", allowPrivate: true},
+		{name: "rfc1918 192.168/16", ip: "192.168.1.2", allowPrivate: true},
+		{name: "rfc6598 100.64/10", ip: "100.64.1.2", allowPrivate: true},
+		{name: "rfc4193 fd00::/8", ip: "fd00::1234", allowPrivate: true},
+		{name: "ipv4 broadcast", ip: "255.255.255.255"},
+		{name: "ipv4 unspecified", ip: "0.0.0.0"},
+		{name: "ipv6 unspecified", ip: "::"},
+		{name: "ipv4 localhost", ip: "127.0.0.1"},
+		{name: "rfc3927 169.254/16", ip: "169.254.1.2"},
+		{name: "rfc5737 192.0.2/24", ip: "192.0.2.1"},
+		{name: "rfc6052 with rfc1918", ip: "64:ff9b::a00:1"},
+		{name: "ipv4", ip: "12.1.2.3", routable: true, allowPrivate: true},
+		{name: "ipv6", ip: "2602:100::1", routable: true, allowPrivate: true},
+	}
+
+	allowPrivate := RoutabilityChecker{AllowPrivate: true}
+	var strict RoutabilityChecker
+	for _, test := range tests {
+		na := wire.NewNetAddressIPPort(net.ParseIP(test.ip), 8333,
+			wire.SFNodeNetwork)
+		if got := IsRoutable(na); got != test.routable {
+			t.Errorf("%q: unexpected IsRoutable result - got %v, want %v",
+				test.name, got, test.routable)
+		}
+		if got := strict.IsRoutable(na); got != test.routable {
+			t.Errorf("%q: unexpected strict IsRoutable result - got %v, "+
+				"want %v", test.name, got, test.routable)
+		}
+		if got := allowPrivate.IsRoutable(na); got != test.allowPrivate {
+			t.Errorf("%q: unexpected allow private IsRoutable result - "+
+				"got %v, want %v", test.name, got, test.allowPrivate)
+		}
+	}
+}
+
+// TestRoutabilityReport ensures the routability report for various addresses
+// describes the expected classification and that its verdict agrees with
+// IsRoutable.
+func TestRoutabilityReport(t *testing.T) {
+	tests := []struct {
+		name string
+		ip   string
+		want string
+	}{
+		{name: "public ipv4", ip: "12.1.2.3", want: "routable IPv4"},
+		{name: "public ipv6", ip: "2602:100::1", want: "routable IPv6"},
+		{name: "rfc1918", ip: "192.168.1.2", want: "unroutable: RFC1918 private"},
+		{name: "rfc5737 documentation", ip: "192.0.2.1",
+			want: "unroutable: RFC5737 documentation"},
+		{name: "rfc3849 documentation", ip: "2001:db8::1",
+			want: "unroutable: RFC3849 documentation"},
+		{name: "ipv4 localhost", ip: "127.0.0.1", want: "unroutable: local"},
+		{name: "ipv4 unspecified", ip: "0.0.0.0", want: "unroutable: unspecified"},
+		{name: "ipv4 broadcast", ip: "255.255.255.255",
+			want: "unroutable: broadcast"},
+		{name: "rfc6598", ip: "100.64.1.2",
+			want: "unroutable: RFC6598 shared address space"},
+		{name: "tor onioncat", ip: "fd87:d87e:eb43:1234::5678",
+			want: "Tor (OnionCat)"},
+		{name: "cjdns", ip: "fc12:3456::1",
+			want: "unroutable: RFC4193 unique local, CJDNS"},
+		{name: "yggdrasil", ip: "200:1234:5678::1", want: "routable Yggdrasil"},
+		{name: "nat64 public", ip: "64:ff9b::c01:203",
+			want: "routable IPv6 (NAT64)"},
+		{name: "nat64 rfc1918", ip: "64:ff9b::a00:1",
+			want: "unroutable: NAT64 embedding RFC1918 private"},
+	}
+
+	for _, test := range tests {
+		na := wire.NewNetAddressIPPort(net.ParseIP(test.ip), 8333,
+			wire.SFNodeNetwork)
+		got := RoutabilityReport(na)
+		if got != test.want {
+			t.Errorf("%q: unexpected report - got %q, want %q", test.name,
+				got, test.want)
+		}
+		unroutable := strings.HasPrefix(got, "unroutable")
+		if routable := IsRoutable(na); routable == unroutable {
+			t.Errorf("%q: report %q disagrees with IsRoutable result %v",
+				test.name, got, routable)
+		}
+	}
+}
+
+// TestAddressType ensures addresses are classified into the expected network
+// address types.
+func TestAddressType(t *testing.T) {
+	tests := []struct {
+		name string
+		ip   string
+		want NetworkAddress
+	}{
+		{name: "ipv4 localhost", ip: "127.0.0.1", want: LocalAddress},
+		{name: "ipv6 localhost", ip: "::1", want: LocalAddress},
+		{name: "ipv4 zero", ip: "0.0.0.0", want: LocalAddress},
+		{name: "ipv4", ip: "12.1.2.3", want: IPv4Address},
+		{name: "ipv4 mapped ipv6", ip: "::ffff:c01:203", want: IPv4Address},
+		{name: "ipv4 private", ip: "10.0.0.1", want: IPv4Address},
+		{name: "ipv6", ip: "2602:100::1", want: IPv6Address},
+		{name: "ipv6 teredo", ip: "2001::1", want: IPv6Address},
+		{name: "tor onioncat", ip: "fd87:d87e:eb43:1234::5678", want: OnionAddress},
+	}
+
+	for _, test := range tests {
+		na := wire.NewNetAddressIPPort(net.ParseIP(test.ip), 8333,
+			wire.SFNodeNetwork)
+		if got := AddressType(na); got != test.want {
+			t.Errorf("%q: unexpected address type - got %v, want %v",
+				test.name, got, test.want)
+		}
+	}
+}
+
+// TestNetworkAddressStringer tests the stringized output for the
+// NetworkAddress type.
+func TestNetworkAddressStringer(t *testing.T) {
+	tests := []struct {
+		in   NetworkAddress
+		want string
+	}{
+		{LocalAddress, "local"},
+		{IPv4Address, "ipv4"},
+		{IPv6Address, "ipv6"},
+		{OnionAddress, "onion"},
+		{0xff, "unknown NetworkAddress (255)"},
+		{-1, "unknown NetworkAddress (-1)"},
+	}
+
+	for i, test := range tests {
+		if got := test.in.String(); got != test.want {
+			t.Errorf("String #%d\n got: %s want: %s", i, got, test.want)
+		}
+	}
+}
+
+// TestTransportFor ensures the transport returned for each network address
+// type is the expected value and that unknown types are rejected.
+func TestTransportFor(t *testing.T) {
+	tests := []struct {
+		name    string
+		netType NetworkAddress
+		want    string
+		wantErr bool
+	}{
+		{name: "local", netType: LocalAddress, want: "clearnet"},
+		{name: "ipv4", netType: IPv4Address, want: "clearnet"},
+		{name: "ipv6", netType: IPv6Address, want: "clearnet"},
+		{name: "onion", netType: OnionAddress, want: "tor"},
+		{name: "unknown", netType: OnionAddress + 1, wantErr: true},
+	}
+
+	for _, test := range tests {
+		transport, err := TransportFor(test.netType)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected error for type %d", test.name,
+					test.netType)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.name, err)
+			continue
+		}
+		if transport != test.want {
+			t.Errorf("%q: unexpected transport - got %q, want %q",
+				test.name, transport, test.want)
+		}
+	}
+}
+
+// TestReachableTypes ensures the network address types reported as reachable
+// match the enabled transports.
+func TestReachableTypes(t *testing.T) {
+	tests := []struct {
+		name     string
+		haveIPv4 bool
+		haveIPv6 bool
+		haveTor  bool
+		want     []NetworkAddress
+	}{
+		{name: "nothing enabled", want: nil},
+		{name: "ipv4 only", haveIPv4: true, want: []NetworkAddress{IPv4Address}},
+		{name: "ipv6 only", haveIPv6: true, want: []NetworkAddress{IPv6Address}},
+		{name: "tor only", haveTor: true, want: []NetworkAddress{OnionAddress}},
+		{name: "dual stack", haveIPv4: true, haveIPv6: true,
+			want: []NetworkAddress{IPv4Address, IPv6Address}},
+		{name: "ipv4 and tor", haveIPv4: true, haveTor: true,
+			want: []NetworkAddress{IPv4Address, OnionAddress}},
+		{name: "everything", haveIPv4: true, haveIPv6: true, haveTor: true,
+			want: []NetworkAddress{IPv4Address, IPv6Address, OnionAddress}},
+	}
+
+	for _, test := range tests {
+		got := ReachableTypes(test.haveIPv4, test.haveIPv6, test.haveTor)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: unexpected reachable types - got %v, want %v",
+				test.name, got, test.want)
+		}
+	}
+}
+
+// TestNewGroups ensures NewGroups only reports the groups of incoming addresses
+// that are not already covered by the existing addresses.
+func TestNewGroups(t *testing.T) {
+	toNetAddrs := func(ips ...string) []*wire.NetAddress {
+		addrs := make([]*wire.NetAddress, 0, len(ips))
+		for _, ip := range ips {
+			addrs = append(addrs, wire.NewNetAddressIPPort(net.ParseIP(ip),
+				8333, wire.SFNodeNetwork))
+		}
+		return addrs
+	}
+
+	tests := []struct {
+		name     string
+		existing []*wire.NetAddress
+		incoming []*wire.NetAddress
+		want     []string
+	}{{
+		name:     "no existing addresses",
+		existing: nil,
+		incoming: toNetAddrs("12.1.2.3", "12.1.9.9", "173.1.2.3"),
+		want:     []string{"12.1.0.0", "173.1.0.0"},
+	}, {
+		name:     "all incoming groups already known",
+		existing: toNetAddrs("12.1.2.3", "173.1.2.3"),
+		incoming: toNetAddrs("12.1.200.1", "173.1.5.5"),
+		want:     nil,
+	}, {
+		name:     "overlapping and novel groups",
+		existing: toNetAddrs("12.1.2.3", "2602:100::1"),
+		incoming: toNetAddrs("12.1.4.4", "196.1.2.3", "2602:100::2",
+			"fd87:d87e:eb43:1234::5678", "196.1.7.7"),
+		want: []string{"196.1.0.0", "tor:2"},
+	}}
+
+	for _, test := range tests {
+		got := NewGroups(test.existing, test.incoming)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: unexpected new groups - got %v, want %v",
+				test.name, got, test.want)
+		}
+	}
+}
+
+// TestConnectivityDiversity ensures the number of distinct network groups is
+// calculated correctly when addresses collapse into the same group.
+func TestConnectivityDiversity(t *testing.T) {
+	tests := []struct {
+		name string
+		ips  []string
+		want int
+	}{{
+		name: "no addresses",
+		ips:  nil,
+		want: 0,
+	}, {
+		name: "all distinct groups",
+		ips:  []string{"12.1.2.3", "173.1.2.3", "2602:100::1"},
+		want: 3,
+	}, {
+		name: "ipv4 addresses in the same /16",
+		ips:  []string{"12.1.2.3", "12.1.4.5", "12.1.200.1", "173.1.2.3"},
+		want: 2,
+	}, {
+		name: "tor addresses in the same group",
+		ips: []string{"fd87:d87e:eb43:1234::5678",
+			"fd87:d87e:eb43:1245::6789", "fd87:d87e:eb43:1345::6789"},
+		want: 2,
+	}, {
+		name: "mixed with translated ipv4",
+		ips:  []string{"12.1.2.3", "2002:0c01:0203::", "2602:100::1"},
+		want: 2,
+	}}
+
+	for _, test := range tests {
+		addrs := make([]*wire.NetAddress, 0, len(test.ips))
+		for _, ip := range test.ips {
+			addrs = append(addrs, wire.NewNetAddressIPPort(net.ParseIP(ip),
+				8333, wire.SFNodeNetwork))
+		}
+		if got := ConnectivityDiversity(addrs); got != test.want {
+			t.Errorf("%q: unexpected diversity - got %d, want %d",
+				test.name, got, test.want)
+		}
+	}
+}
+
+// TestSameSubnet ensures addresses are only reported to be in the same subnet
+// when they share the requested prefix and are of the same family.
+func TestSameSubnet(t *testing.T) {
+	tests := []struct {
+		name      string
+		a, b      string
+		prefixLen int
+		want      bool
+	}{
+		{name: "ipv4 same /24", a: "192.168.1.10", b: "192.168.1.200", prefixLen: 24, want: true},
+		{name: "ipv4 different /24", a: "192.168.1.10", b: "192.168.2.10", prefixLen: 24, want: false},
+		{name: "ipv4 same /16", a: "192.168.1.10", b: "192.168.2.10", prefixLen: 16, want: true},
+		{name: "ipv4 same /32", a: "10.0.0.1", b: "10.0.0.1", prefixLen: 32, want: true},
+		{name: "ipv4 different /32", a: "10.0.0.1", b: "10.0.0.2", prefixLen: 32, want: false},
+		{name: "ipv4 /0", a: "10.0.0.1", b: "12.1.2.3", prefixLen: 0, want: true},
+		{name: "ipv4 prefix too long", a: "10.0.0.1", b: "10.0.0.1", prefixLen: 33, want: false},
+		{name: "negative prefix", a: "10.0.0.1", b: "10.0.0.1", prefixLen: -1, want: false},
+		{name: "ipv6 same /64", a: "fd00:1:2:3::1", b: "fd00:1:2:3::ffff", prefixLen: 64, want: true},
+		{name: "ipv6 different /64", a: "fd00:1:2:3::1", b: "fd00:1:2:4::1", prefixLen: 64, want: false},
+		{name: "ipv6 /128 prefix", a: "2602:100::1", b: "2602:100::1", prefixLen: 128, want: true},
+		{name: "cross family", a: "10.0.0.1", b: "::ffff:0:a00:1", prefixLen: 8, want: false},
+		{name: "cross family /0", a: "10.0.0.1", b: "2602:100::1", prefixLen: 0, want: false},
+	}
+
+	for _, test := range tests {
+		a := wire.NewNetAddressIPPort(net.ParseIP(test.a), 8333, 0)
+		b := wire.NewNetAddressIPPort(net.ParseIP(test.b), 8333, 0)
+		if got := SameSubnet(a, b, test.prefixLen); got != test.want {
+			t.Errorf("%q: unexpected result - got %v, want %v", test.name,
+				got, test.want)
+		}
+	}
+}
+
+// TestCoalesceIPv4 ensures IPv4 addresses are coalesced into the expected
+// minimal set of covering prefixes.
+func TestCoalesceIPv4(t *testing.T) {
+	// rangeIPs returns the addresses from the first address through the
+	// last address inclusive.
+	rangeIPs := func(first, last string) []net.IP {
+		var ips []net.IP
+		ip := net.ParseIP(first).To4()
+		end := net.ParseIP(last).To4()
+		for {
+			ips = append(ips, append(net.IP(nil), ip...))
+			if ip.Equal(end) {
+				return ips
+			}
+			for i := len(ip) - 1; i >= 0; i-- {
+				ip[i]++
+				if ip[i] != 0 {
+					break
+				}
+			}
+		}
+	}
+	parseIPs := func(strs ...string) []net.IP {
+		ips := make([]net.IP, 0, len(strs))
+		for _, str := range strs {
+			ips = append(ips, net.ParseIP(str))
+		}
+		return ips
+	}
+	concat := func(lists ...[]net.IP) []net.IP {
+		var ips []net.IP
+		for _, list := range lists {
+			ips = append(ips, list...)
+		}
+		return ips
+	}
+
+	tests := []struct {
+		name  string
+		addrs []net.IP
+		want  []string
+	}{{
+		name:  "no addresses",
+		addrs: nil,
+		want:  nil,
+	}, {
+		name:  "single address",
+		addrs: parseIPs("12.1.2.3"),
+		want:  []string{"12.1.2.3/32"},
+	}, {
+		name:  "full /24 and /25",
+		addrs: concat(rangeIPs("10.0.1.0", "10.0.1.255"), rangeIPs("10.0.3.128", "10.0.3.255")),
+		want:  []string{"10.0.1.0/24", "10.0.3.128/25"},
+	}, {
+		name:  "adjacent /24s merge into a /23",
+		addrs: concat(rangeIPs("10.0.3.0", "10.0.3.255"), rangeIPs("10.0.2.0", "10.0.2.255")),
+		want:  []string{"10.0.2.0/23"},
+	}, {
+		name:  "unaligned run",
+		addrs: rangeIPs("10.0.0.1", "10.0.0.6"),
+		want:  []string{"10.0.0.1/32", "10.0.0.2/31", "10.0.0.4/31", "10.0.0.6/32"},
+	}, {
+		name:  "scattered addresses remain /32s",
+		addrs: parseIPs("12.1.2.3", "173.1.2.3", "12.1.2.5", "196.1.2.3"),
+		want:  []string{"12.1.2.3/32", "12.1.2.5/32", "173.1.2.3/32", "196.1.2.3/32"},
+	}, {
+		name:  "duplicates and ipv6 ignored",
+		addrs: parseIPs("12.1.2.4", "2602:100::1", "12.1.2.5", "12.1.2.4", "::ffff:c01:205"),
+		want:  []string{"12.1.2.4/31"},
+	}, {
+		name:  "top of address space",
+		addrs: rangeIPs("255.255.255.252", "255.255.255.255"),
+		want:  []string{"255.255.255.252/30"},
+	}}
+
+	for _, test := range tests {
+		var got []string
+		for _, prefix := range CoalesceIPv4(test.addrs) {
+			got = append(got, prefix.String())
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: unexpected prefixes - got %v, want %v", test.name,
+				got, test.want)
+		}
+	}
+}
+
+// TestLikelySamePeerGroup ensures addresses are only reported as likely being
+// part of the same peer group when they share a group key.
+func TestLikelySamePeerGroup(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want bool
+	}{
+		{name: "ipv4 same /16", a: "12.1.2.3", b: "12.1.200.100", want: true},
+		{name: "ipv4 different /16", a: "12.1.2.3", b: "12.2.2.3", want: false},
+		{name: "ipv4 and 6to4 encapsulated", a: "12.1.2.3",
+			b: "2002:c01:203::", want: true},
+		{name: "ipv6 same /32", a: "2602:100::1", b: "2602:100:ffff::1",
+			want: true},
+		{name: "ipv6 different /32", a: "2602:100::1", b: "2602:101::1",
+			want: false},
+		{name: "ipv6 he.net same /36", a: "2001:470:1f10::1",
+			b: "2001:470:1000::2", want: true},
+		{name: "ipv6 he.net different /36", a: "2001:470:1f10::1",
+			b: "2001:470:2f10::1", want: false},
+		{name: "tor same nibble", a: "fd87:d87e:eb43:1234::5678",
+			b: "fd87:d87e:eb43:1245::6789", want: true},
+		{name: "tor different nibble", a: "fd87:d87e:eb43:1234::5678",
+			b: "fd87:d87e:eb43:1345::6789", want: false},
+		{name: "ipv4 and tor", a: "12.1.2.3", b: "fd87:d87e:eb43:1234::5678",
+			want: false},
+		{name: "ipv4 and ipv6", a: "12.1.2.3", b: "2602:100::1", want: false},
+	}
+
+	for _, test := range tests {
+		a := wire.NewNetAddressIPPort(net.ParseIP(test.a), 8333,
+			wire.SFNodeNetwork)
+		b := wire.NewNetAddressIPPort(net.ParseIP(test.b), 8333,
+			wire.SFNodeNetwork)
+		if got := LikelySamePeerGroup(a, b); got != test.want {
+			t.Errorf("%q: unexpected result - got %v, want %v", test.name,
+				got, test.want)
+		}
+		if got := LikelySamePeerGroup(b, a); got != test.want {
+			t.Errorf("%q: unexpected reversed result - got %v, want %v",
+				test.name, got, test.want)
+		}
+	}
+}
@@ -166,11 +166,12 @@ type config struct {
 	PeerIdleTimeout time.Duration `long:"peeridletimeout" description:"The duration of inactivity before a peer is timed out. Valid time units are {s,m,h}. Minimum 15 seconds"`
 
 	// P2P network discovery options.
-	DisableSeeders bool     `long:"noseeders" description:"Disable seeding for peer discovery"`
-	DisableDNSSeed bool     `long:"nodnsseed" description:"DEPRECATED: use --noseeders"`
-	ExternalIPs    []string `long:"externalip" description:"Add an ip to the list of local addresses we claim to listen on to peers"`
-	NoDiscoverIP   bool     `long:"nodiscoverip" description:"Disable automatic network address discovery of local external IPs"`
-	Upnp           bool     `long:"upnp" description:"Use UPnP to map our listening port outside of NAT"`
+	DisableSeeders   bool     `long:"noseeders" description:"Disable seeding for peer discovery"`
+	DisableDNSSeed   bool     `long:"nodnsseed" description:"DEPRECATED: use --noseeders"`
+	ExternalIPs      []string `long:"externalip" description:"Add an ip to the list of local addresses we claim to listen on to peers"`
+	NoDiscoverIP     bool     `long:"nodiscoverip" description:"Disable automatic network address discovery of local external IPs"`
+	Upnp             bool     `long:"upnp" description:"Use UPnP to map our listening port outside of NAT"`
+	UpnpExternalPort uint16   `long:"upnpexternalport" description:"External port to request when mapping the listening port via UPnP (default: same as the listening port)"`
 
 	// Banning options.
 	DisableBanning bool          `long:"nobanning" description:"Disable banning of misbehaving peers"`
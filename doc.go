@@ -107,6 +107,9 @@ Application Options:
       --nodiscoverip           Disable automatic network address discovery of
                                local external IPs
       --upnp                   Use UPnP to map our listening port outside of NAT
+      --upnpexternalport=      External port to request when mapping the
+                               listening port via UPnP (default: same as the
+                               listening port)
       --nobanning              Disable banning of misbehaving peers
       --banduration=           How long to ban misbehaving peers.  Valid time
                                units are {s, m, h}.  Minimum 1 second (default:
@@ -67,6 +67,11 @@ const fileContents = `[Application Options]
 ; will have no effect if external IP addresses are specified.
 ; upnp=1
 
+; Request a different external port than the listen port when mapping it via
+; UPnP.  This is useful when multiple nodes share the same NAT device.  Defaults
+; to the listen port.
+; upnpexternalport=19108
+
 ; Specify the external IP addresses your node is listening on.  One address per
 ; line.  dcrd will not contact 3rd-party sites to obtain external ip addresses.
 ; This means if you are behind NAT, your node will not be able to advertise a
@@ -2859,10 +2859,17 @@ func parseListeners(addrs []string) ([]net.Addr, error) {
 }
 
 func (s *server) upnpUpdateThread(ctx context.Context) {
+	lport, eport, err := upnpMappingPorts(s.chainParams.DefaultPort,
+		cfg.UpnpExternalPort)
+	if err != nil {
+		srvrLog.Errorf("Unable to determine UPnP port mapping: %v", err)
+		s.wg.Done()
+		return
+	}
+
 	// Go off immediately to prevent code duplication, thereafter we renew
 	// lease every 15 minutes.
 	timer := time.NewTimer(0 * time.Second)
-	lport, _ := strconv.ParseInt(s.chainParams.DefaultPort, 10, 16)
 
 	first := true
 out:
@@ -2874,15 +2881,28 @@ out:
 			// TODO: if specific listen port doesn't work then ask for wildcard
 			// listen port?
 			// XXX this assumes timeout is in seconds.
-			listenPort, err := s.nat.AddPortMapping("tcp", int(lport), int(lport),
-				"dcrd listen port", 20*60)
-			if err != nil {
+			listenPort, err := s.nat.AddPortMapping(ctx, "tcp", eport,
+				lport, "dcrd listen port", 20*60)
+			switch {
+			case isPortMappingTableFull(err):
+				// Report the number of entries in the table when
+				// the router supports it to aid in diagnostics.
+				entries, qerr := s.nat.PortMappingNumberOfEntries(ctx)
+				if qerr != nil {
+					srvrLog.Warnf("can't add UPnP port mapping: " +
+						"router port mapping table is full")
+					break
+				}
+				srvrLog.Warnf("can't add UPnP port mapping: router port "+
+					"mapping table is full (%d entries)", entries)
+
+			case err != nil:
 				srvrLog.Warnf("can't add UPnP port mapping: %v", err)
 			}
 			if first && err == nil {
 				// TODO: look this up periodically to see if upnp domain changed
 				// and so did ip.
-				externalip, err := s.nat.GetExternalAddress()
+				externalip, err := s.nat.GetExternalAddress(ctx)
 				if err != nil {
 					srvrLog.Warnf("UPnP can't get external address: %v", err)
 					continue out
@@ -2908,7 +2928,11 @@ out:
 
 	timer.Stop()
 
-	err := s.nat.DeletePortMapping("tcp", int(lport), int(lport))
+	// The provided context is already canceled at this point, so use a
+	// separate context that bounds how long removing the mapping may take.
+	delCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	err = s.nat.DeletePortMapping(delCtx, "tcp", eport, lport)
+	cancel()
 	if err != nil {
 		srvrLog.Warnf("unable to remove UPnP port mapping: %v", err)
 	} else {
@@ -3562,10 +3586,22 @@ func initListeners(ctx context.Context, params *chaincfg.Params, amgr *addrmgr.A
 		}
 	} else {
 		if cfg.Upnp {
+			var caps *upnpCapabilities
 			var err error
-			nat, err = discover(ctx)
-			if err != nil {
+			nat, caps, err = probeUPnP(ctx)
+			switch {
+			case nat == nil:
 				srvrLog.Warnf("Can't discover upnp: %v", err)
+			case err != nil:
+				srvrLog.Warnf("Can't determine upnp router capabilities: %v",
+					err)
+			case len(caps.missing) > 0:
+				srvrLog.Warnf("UPnP router at %s does not support %s, so "+
+					"port forwarding is unlikely to work", caps.externalIP,
+					strings.Join(caps.missing, ", "))
+			default:
+				srvrLog.Debugf("UPnP router at %s supports port forwarding",
+					caps.externalIP)
 			}
 			// nil nat here is fine, just means no upnp on network.
 		}
@@ -48,6 +48,7 @@ import (
 
 type upnpNAT struct {
 	serviceURL string
+	scpdURL    string
 	ourIP      string
 }
 
@@ -66,6 +67,18 @@ func discover(ctx context.Context) (*upnpNAT, error) {
 	socket := conn.(*net.UDPConn)
 	defer socket.Close()
 
+	// Close the socket when the context is canceled so any blocked reads
+	// and writes return immediately.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			socket.Close()
+		case <-done:
+		}
+	}()
+
 	err = socket.SetDeadline(time.Now().Add(3 * time.Second))
 	if err != nil {
 		return nil, err
@@ -83,11 +96,17 @@ func discover(ctx context.Context) (*upnpNAT, error) {
 	for i := 0; i < 3; i++ {
 		_, err = socket.WriteToUDP(message, ssdp)
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
 			return nil, err
 		}
 		var n int
 		n, _, err = socket.ReadFromUDP(answerBytes)
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
 			continue
 			// socket.Close()
 			// return
@@ -109,17 +128,18 @@ func discover(ctx context.Context) (*upnpNAT, error) {
 			continue
 		}
 		locURL := loc[0:endIndex]
-		var serviceURL string
-		serviceURL, err = getServiceURL(locURL)
+		var serviceURL, scpdURL string
+		serviceURL, scpdURL, err = getServiceURL(ctx, locURL)
 		if err != nil {
 			return nil, err
 		}
 		var ourIP string
-		ourIP, err = getOurIP()
+		ourIP, err = getOurIP(ctx)
 		if err != nil {
 			return nil, err
 		}
-		return &upnpNAT{serviceURL: serviceURL, ourIP: ourIP}, nil
+		return &upnpNAT{serviceURL: serviceURL, scpdURL: scpdURL,
+			ourIP: ourIP}, nil
 	}
 	return nil, errors.New("UPnP port discovery failed")
 }
@@ -130,6 +150,7 @@ func discover(ctx context.Context) (*upnpNAT, error) {
 type service struct {
 	ServiceType string `xml:"serviceType"`
 	ControlURL  string `xml:"controlURL"`
+	SCPDURL     string `xml:"SCPDURL"`
 }
 
 // deviceList represents the deviceList type in an UPnP xml description.
@@ -176,6 +197,29 @@ type root struct {
 	Device      device
 }
 
+// action represents the action type in a UPnP service description.
+// Only the parts we care about are present and thus the xml may have more
+// fields than present in the structure.
+type action struct {
+	Name string `xml:"name"`
+}
+
+// actionList represents the actionList type in a UPnP service description.
+// Only the parts we care about are present and thus the xml may have more
+// fields than present in the structure.
+type actionList struct {
+	XMLName xml.Name `xml:"actionList"`
+	Action  []action `xml:"action"`
+}
+
+// scpd represents the root document of a UPnP service description.
+// Only the parts we care about are present and thus the xml may have more
+// fields than present in the structure.
+type scpd struct {
+	XMLName    xml.Name `xml:"scpd"`
+	ActionList actionList
+}
+
 // getChildDevice searches the children of device for a device with the given
 // type.
 func getChildDevice(d *device, deviceType string) *device {
@@ -199,18 +243,23 @@ func getChildService(d *device, serviceType string) *service {
 }
 
 // getOurIP returns a best guess at what the local IP is.
-func getOurIP() (ip string, err error) {
+func getOurIP(ctx context.Context) (ip string, err error) {
 	hostname, err := os.Hostname()
 	if err != nil {
 		return
 	}
-	return net.LookupCNAME(hostname)
+	return net.DefaultResolver.LookupCNAME(ctx, hostname)
 }
 
 // getServiceURL parses the xml description at the given root url to find the
-// url for the WANIPConnection service to be used for port forwarding.
-func getServiceURL(rootURL string) (url string, err error) {
-	r, err := http.Get(rootURL)
+// control url for the WANIPConnection service to be used for port forwarding
+// along with the url of its service description.
+func getServiceURL(ctx context.Context, rootURL string) (url, scpdURL string, err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", rootURL, nil)
+	if err != nil {
+		return
+	}
+	r, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return
 	}
@@ -245,9 +294,39 @@ func getServiceURL(rootURL string) (url string, err error) {
 		return
 	}
 	url = combineURL(rootURL, d.ControlURL)
+	if d.SCPDURL != "" {
+		scpdURL = combineURL(rootURL, d.SCPDURL)
+	}
 	return
 }
 
+// getServiceActions parses the service description at the given url and
+// returns the names of the actions the service supports.
+func getServiceActions(ctx context.Context, scpdURL string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", scpdURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	if r.StatusCode >= 400 {
+		return nil, fmt.Errorf("%d", r.StatusCode)
+	}
+	var desc scpd
+	err = xml.NewDecoder(r.Body).Decode(&desc)
+	if err != nil {
+		return nil, err
+	}
+	actions := make([]string, 0, len(desc.ActionList.Action))
+	for _, a := range desc.ActionList.Action {
+		actions = append(actions, a.Name)
+	}
+	return actions, nil
+}
+
 // combineURL appends subURL onto rootURL.
 func combineURL(rootURL, subURL string) string {
 	protocolEnd := "://"
@@ -271,22 +350,65 @@ type soapEnvelope struct {
 	Body    soapBody `xml:"Body"`
 }
 
+// soapFault represents the parts of a SOAP fault envelope that carry the error
+// reported by a UPnP device.  Fields we don't care about are elided.
+type soapFault struct {
+	XMLName     xml.Name `xml:"Envelope"`
+	Code        int      `xml:"Body>Fault>detail>UPnPError>errorCode"`
+	Description string   `xml:"Body>Fault>detail>UPnPError>errorDescription"`
+}
+
+// upnpErrNoPortMapsAvailable is the UPnP error code reported by a router when
+// its port mapping table is full.
+const upnpErrNoPortMapsAvailable = 728
+
+// upnpError describes an error reported by a UPnP device in response to a SOAP
+// request.
+type upnpError struct {
+	function    string
+	code        int
+	description string
+}
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e *upnpError) Error() string {
+	return fmt.Sprintf("error %d (%s) for %s", e.code, e.description,
+		e.function)
+}
+
+// isPortMappingTableFull returns whether or not the provided error indicates
+// the port mapping table of the UPnP router is full.
+func isPortMappingTableFull(err error) bool {
+	var uerr *upnpError
+	return errors.As(err, &uerr) && uerr.code == upnpErrNoPortMapsAvailable
+}
+
 // soapRequests performs a soap request with the given parameters and returns
 // the xml replied stripped of the soap headers. in the case that the request is
 // unsuccessful the an error is returned.
-func soapRequest(url, function, message string) (replyXML []byte, err error) {
+func soapRequest(ctx context.Context, url, function, message string) (replyXML []byte, err error) {
+	return soapActionRequest(ctx, url,
+		"urn:schemas-upnp-org:service:WANIPConnection:1", function, message)
+}
+
+// soapActionRequest performs a soap request for the given function of the
+// provided service type and returns the xml replied stripped of the soap
+// headers.  When the device reports a UPnP error, it is returned as a
+// *upnpError.
+func soapActionRequest(ctx context.Context, url, serviceType, function, message string) (replyXML []byte, err error) {
 	fullMessage := "<?xml version=\"1.0\" ?>" +
 		"<s:Envelope xmlns:s=\"http://schemas.xmlsoap.org/soap/envelope/\" s:encodingStyle=\"http://schemas.xmlsoap.org/soap/encoding/\">\r\n" +
 		"<s:Body>" + message + "</s:Body></s:Envelope>"
 
-	req, err := http.NewRequest("POST", url, strings.NewReader(fullMessage))
+	req, err := http.NewRequestWithContext(ctx, "POST", url,
+		strings.NewReader(fullMessage))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "text/xml ; charset=\"utf-8\"")
 	req.Header.Set("User-Agent", "Darwin/10.0.0, UPnP/1.0, MiniUPnPc/1.3")
 	//req.Header.Set("Transfer-Encoding", "chunked")
-	req.Header.Set("SOAPAction", "\"urn:schemas-upnp-org:service:WANIPConnection:1#"+function+"\"")
+	req.Header.Set("SOAPAction", "\""+serviceType+"#"+function+"\"")
 	req.Header.Set("Connection", "Close")
 	req.Header.Set("Cache-Control", "no-cache")
 	req.Header.Set("Pragma", "no-cache")
@@ -300,7 +422,11 @@ func soapRequest(url, function, message string) (replyXML []byte, err error) {
 	}
 
 	if r.StatusCode >= 400 {
-		// log.Stderr(function, r.StatusCode)
+		var fault soapFault
+		if xml.NewDecoder(r.Body).Decode(&fault) == nil && fault.Code != 0 {
+			return nil, &upnpError{function: function, code: fault.Code,
+				description: fault.Description}
+		}
 		err = errors.New("error " + strconv.Itoa(r.StatusCode) + " for " + function)
 		r = nil
 		return
@@ -322,9 +448,9 @@ type getExternalIPAddressResponse struct {
 
 // GetExternalAddress implements the NAT interface by fetching the external IP
 // from the UPnP router.
-func (n *upnpNAT) GetExternalAddress() (addr net.IP, err error) {
+func (n *upnpNAT) GetExternalAddress(ctx context.Context) (addr net.IP, err error) {
 	message := "<u:GetExternalIPAddress xmlns:u=\"urn:schemas-upnp-org:service:WANIPConnection:1\"/>\r\n"
-	response, err := soapRequest(n.serviceURL, "GetExternalIPAddress", message)
+	response, err := soapRequest(ctx, n.serviceURL, "GetExternalIPAddress", message)
 	if err != nil {
 		return nil, err
 	}
@@ -342,9 +468,38 @@ func (n *upnpNAT) GetExternalAddress() (addr net.IP, err error) {
 	return addr, nil
 }
 
+// queryStateVariableResponse represents the XML response to a
+// QueryStateVariable SOAP request.
+type queryStateVariableResponse struct {
+	XMLName xml.Name `xml:"QueryStateVariableResponse"`
+	Return  string   `xml:"return"`
+}
+
+// PortMappingNumberOfEntries returns the number of port mapping entries the
+// UPnP router reports are currently in its mapping table.  This is useful to
+// diagnose why new port mappings can't be created.
+func (n *upnpNAT) PortMappingNumberOfEntries(ctx context.Context) (int, error) {
+	const controlService = "urn:schemas-upnp-org:control-1-0"
+	message := "<u:QueryStateVariable xmlns:u=\"" + controlService + "\">" +
+		"<u:varName>PortMappingNumberOfEntries</u:varName>" +
+		"</u:QueryStateVariable>\r\n"
+	response, err := soapActionRequest(ctx, n.serviceURL, controlService,
+		"QueryStateVariable", message)
+	if err != nil {
+		return 0, err
+	}
+
+	var reply queryStateVariableResponse
+	err = xml.Unmarshal(response, &reply)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(reply.Return))
+}
+
 // AddPortMapping implements the NAT interface by setting up a port forwarding
 // from the UPnP router to the local machine with the given ports and protocol.
-func (n *upnpNAT) AddPortMapping(protocol string, externalPort, internalPort int, description string, timeout int) (mappedExternalPort int, err error) {
+func (n *upnpNAT) AddPortMapping(ctx context.Context, protocol string, externalPort, internalPort int, description string, timeout int) (mappedExternalPort int, err error) {
 	// A single concatenation would break ARM compilation.
 	message := "<u:AddPortMapping xmlns:u=\"urn:schemas-upnp-org:service:WANIPConnection:1\">\r\n" +
 		"<NewRemoteHost></NewRemoteHost><NewExternalPort>" + strconv.Itoa(externalPort)
@@ -356,7 +511,7 @@ func (n *upnpNAT) AddPortMapping(protocol string, externalPort, internalPort int
 		"</NewPortMappingDescription><NewLeaseDuration>" + strconv.Itoa(timeout) +
 		"</NewLeaseDuration></u:AddPortMapping>"
 
-	response, err := soapRequest(n.serviceURL, "AddPortMapping", message)
+	response, err := soapRequest(ctx, n.serviceURL, "AddPortMapping", message)
 	if err != nil {
 		return
 	}
@@ -372,14 +527,14 @@ func (n *upnpNAT) AddPortMapping(protocol string, externalPort, internalPort int
 
 // DeletePortMapping implements the NAT interface by removing up a port forwarding
 // from the UPnP router to the local machine with the given ports and.
-func (n *upnpNAT) DeletePortMapping(protocol string, externalPort, internalPort int) (err error) {
+func (n *upnpNAT) DeletePortMapping(ctx context.Context, protocol string, externalPort, internalPort int) (err error) {
 
 	message := "<u:DeletePortMapping xmlns:u=\"urn:schemas-upnp-org:service:WANIPConnection:1\">\r\n" +
 		"<NewRemoteHost></NewRemoteHost><NewExternalPort>" + strconv.Itoa(externalPort) +
 		"</NewExternalPort><NewProtocol>" + strings.ToUpper(protocol) + "</NewProtocol>" +
 		"</u:DeletePortMapping>"
 
-	response, err := soapRequest(n.serviceURL, "DeletePortMapping", message)
+	response, err := soapRequest(ctx, n.serviceURL, "DeletePortMapping", message)
 	if err != nil {
 		return
 	}
@@ -389,3 +544,95 @@ func (n *upnpNAT) DeletePortMapping(protocol string, externalPort, internalPort
 	_ = response
 	return
 }
+
+// upnpMappingPorts returns the internal and external ports to use when mapping
+// the provided listening port via UPnP.  The external port is the provided
+// external port when it is non-zero and otherwise defaults to the listening
+// port.
+func upnpMappingPorts(listenPort string, externalPort uint16) (internal, external int, err error) {
+	port, err := strconv.ParseUint(listenPort, 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid listening port %q: %v", listenPort,
+			err)
+	}
+	internal, external = int(port), int(port)
+	if externalPort != 0 {
+		external = int(externalPort)
+	}
+	return internal, external, nil
+}
+
+// upnpRequiredActions are the WANIPConnection actions that must be supported
+// by a UPnP router in order to forward ports.
+var upnpRequiredActions = []string{
+	"AddPortMapping",
+	"DeletePortMapping",
+	"GetExternalIPAddress",
+}
+
+// upnpCapabilities describes what a UPnP router reports it is able to do.
+type upnpCapabilities struct {
+	externalIP net.IP
+	actions    []string
+
+	// missing are the actions required to forward ports that the router
+	// does not support.  Port forwarding will not work unless it is empty.
+	missing []string
+}
+
+// missingActions returns the actions required to forward ports that the UPnP
+// router does not support.
+func (c *upnpCapabilities) missingActions() []string {
+	var missing []string
+	for _, required := range upnpRequiredActions {
+		var found bool
+		for _, action := range c.actions {
+			if action == required {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, required)
+		}
+	}
+	return missing
+}
+
+// Probe reports the external address of the UPnP router along with the actions
+// advertised by its WANIPConnection service and any of the actions required to
+// forward ports that are missing without creating any port mappings.  It is
+// intended to determine whether port forwarding would work.
+func (n *upnpNAT) Probe(ctx context.Context) (*upnpCapabilities, error) {
+	if n.scpdURL == "" {
+		return nil, errors.New("no WAN IP connection service description")
+	}
+	actions, err := getServiceActions(ctx, n.scpdURL)
+	if err != nil {
+		return nil, err
+	}
+	externalIP, err := n.GetExternalAddress(ctx)
+	if err != nil {
+		return nil, err
+	}
+	caps := &upnpCapabilities{externalIP: externalIP, actions: actions}
+	caps.missing = caps.missingActions()
+	return caps, nil
+}
+
+// probeUPnP searches the local network for a UPnP router and probes it to
+// determine whether port forwarding would work.  The discovered NAT is returned
+// whenever discovery succeeds, even when probing its capabilities fails, so
+// callers may still attempt to use it.  In that case the returned capabilities
+// are nil and the error describes the probe failure.
+func probeUPnP(ctx context.Context) (*upnpNAT, *upnpCapabilities, error) {
+	nat, err := discover(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	caps, err := nat.Probe(ctx)
+	if err != nil {
+		return nat, nil, err
+	}
+	return nat, caps, nil
+}
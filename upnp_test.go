@@ -0,0 +1,457 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockGatewayRootDesc is the root device description served by the mock UPnP
+// gateway.
+const mockGatewayRootDesc = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+<specVersion><major>1</major><minor>0</minor></specVersion>
+<device>
+<deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+<deviceList><device>
+<deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+<deviceList><device>
+<deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+<serviceList><service>
+<serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+<controlURL>/ctl/IPConn</controlURL>
+<SCPDURL>/WANIPCn.xml</SCPDURL>
+</service></serviceList>
+</device></deviceList>
+</device></deviceList>
+</device>
+</root>`
+
+// mockGatewaySCPD is the WANIPConnection service description served by the
+// mock UPnP gateway.
+const mockGatewaySCPD = `<?xml version="1.0"?>
+<scpd xmlns="urn:schemas-upnp-org:service-1-0">
+<specVersion><major>1</major><minor>0</minor></specVersion>
+<actionList>
+<action><name>AddPortMapping</name></action>
+<action><name>DeletePortMapping</name></action>
+<action><name>GetExternalIPAddress</name></action>
+</actionList>
+</scpd>`
+
+// mockGatewayExternalIP is the external address reported by the mock UPnP
+// gateway.
+const mockGatewayExternalIP = "203.0.113.7"
+
+// mockGateway is a minimal UPnP internet gateway device that serves its
+// descriptions and records the SOAP actions it is asked to perform.
+type mockGateway struct {
+	*httptest.Server
+
+	mtx  sync.Mutex
+	scpd string
+
+	// tableEntries is the number of port mapping entries the gateway
+	// reports.  The table is treated as full when it is non-zero.
+	tableEntries int
+
+	actions  []string
+	requests []string
+}
+
+// newMockGateway returns a started mock UPnP gateway.  The caller is
+// responsible for closing it.
+func newMockGateway() *mockGateway {
+	g := &mockGateway{scpd: mockGatewaySCPD}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rootDesc.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mockGatewayRootDesc))
+	})
+	mux.HandleFunc("/WANIPCn.xml", func(w http.ResponseWriter, r *http.Request) {
+		g.mtx.Lock()
+		scpd := g.scpd
+		g.mtx.Unlock()
+		w.Write([]byte(scpd))
+	})
+	mux.HandleFunc("/ctl/IPConn", g.handleSOAP)
+	g.Server = httptest.NewServer(mux)
+	return g
+}
+
+// handleSOAP records the requested SOAP action and replies with a successful
+// response for it.
+func (g *mockGateway) handleSOAP(w http.ResponseWriter, r *http.Request) {
+	soapAction := strings.Trim(r.Header.Get("SOAPAction"), "\"")
+	action := soapAction[strings.LastIndex(soapAction, "#")+1:]
+	body, _ := ioutil.ReadAll(r.Body)
+
+	g.mtx.Lock()
+	g.actions = append(g.actions, action)
+	g.requests = append(g.requests, string(body))
+	tableEntries := g.tableEntries
+	g.mtx.Unlock()
+
+	var reply string
+	switch action {
+	case "AddPortMapping":
+		if tableEntries == 0 {
+			reply = "<u:AddPortMappingResponse " +
+				"xmlns:u=\"urn:schemas-upnp-org:service:WANIPConnection:1\"/>"
+			break
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		reply = "<s:Fault><faultcode>s:Client</faultcode>" +
+			"<faultstring>UPnPError</faultstring><detail>" +
+			"<UPnPError xmlns=\"urn:schemas-upnp-org:control-1-0\">" +
+			"<errorCode>728</errorCode>" +
+			"<errorDescription>NoPortMapsAvailable</errorDescription>" +
+			"</UPnPError></detail></s:Fault>"
+	case "QueryStateVariable":
+		reply = "<u:QueryStateVariableResponse " +
+			"xmlns:u=\"urn:schemas-upnp-org:control-1-0\"><return>" +
+			strconv.Itoa(tableEntries) +
+			"</return></u:QueryStateVariableResponse>"
+	case "GetExternalIPAddress":
+		reply = "<u:GetExternalIPAddressResponse " +
+			"xmlns:u=\"urn:schemas-upnp-org:service:WANIPConnection:1\">" +
+			"<NewExternalIPAddress>" + mockGatewayExternalIP +
+			"</NewExternalIPAddress></u:GetExternalIPAddressResponse>"
+	default:
+		reply = "<u:" + action + "Response " +
+			"xmlns:u=\"urn:schemas-upnp-org:service:WANIPConnection:1\"/>"
+	}
+	w.Write([]byte("<?xml version=\"1.0\"?><s:Envelope " +
+		"xmlns:s=\"http://schemas.xmlsoap.org/soap/envelope/\"><s:Body>" +
+		reply + "</s:Body></s:Envelope>"))
+}
+
+// setSCPD replaces the WANIPConnection service description served by the
+// gateway.
+func (g *mockGateway) setSCPD(scpd string) {
+	g.mtx.Lock()
+	g.scpd = scpd
+	g.mtx.Unlock()
+}
+
+// setTableEntries sets the number of port mapping entries the gateway reports.
+func (g *mockGateway) setTableEntries(entries int) {
+	g.mtx.Lock()
+	g.tableEntries = entries
+	g.mtx.Unlock()
+}
+
+// requestedActions returns the SOAP actions requested of the gateway so far.
+func (g *mockGateway) requestedActions() []string {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	return append([]string(nil), g.actions...)
+}
+
+// lastRequest returns the body of the most recent SOAP request made to the
+// gateway.
+func (g *mockGateway) lastRequest() string {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	if len(g.requests) == 0 {
+		return ""
+	}
+	return g.requests[len(g.requests)-1]
+}
+
+// nat returns a UPnP NAT for the mock gateway as discovered from its root
+// device description.
+func (g *mockGateway) nat(t *testing.T) *upnpNAT {
+	t.Helper()
+
+	serviceURL, scpdURL, err := getServiceURL(context.Background(),
+		g.URL+"/rootDesc.xml")
+	if err != nil {
+		t.Fatalf("unable to get service url: %v", err)
+	}
+	return &upnpNAT{serviceURL: serviceURL, scpdURL: scpdURL,
+		ourIP: "192.168.1.2"}
+}
+
+// TestUPnPProbe ensures probing a UPnP gateway reports its external address and
+// supported actions without creating a port mapping.
+func TestUPnPProbe(t *testing.T) {
+	gateway := newMockGateway()
+	defer gateway.Close()
+
+	nat := gateway.nat(t)
+	if want := gateway.URL + "/WANIPCn.xml"; nat.scpdURL != want {
+		t.Fatalf("unexpected scpd url - got %s, want %s", nat.scpdURL, want)
+	}
+
+	caps, err := nat.Probe(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected probe error: %v", err)
+	}
+	if caps.externalIP.String() != mockGatewayExternalIP {
+		t.Fatalf("unexpected external ip - got %v, want %v",
+			caps.externalIP, mockGatewayExternalIP)
+	}
+	wantActions := []string{"AddPortMapping", "DeletePortMapping",
+		"GetExternalIPAddress"}
+	if !reflect.DeepEqual(caps.actions, wantActions) {
+		t.Fatalf("unexpected actions - got %v, want %v", caps.actions,
+			wantActions)
+	}
+	if len(caps.missing) != 0 {
+		t.Fatalf("unexpected missing actions: %v", caps.missing)
+	}
+
+	// Ensure the probe did not request anything other than the external
+	// address from the gateway.
+	requested := gateway.requestedActions()
+	if !reflect.DeepEqual(requested, []string{"GetExternalIPAddress"}) {
+		t.Fatalf("unexpected actions requested by probe: %v", requested)
+	}
+
+	// Ensure missing required actions are reported when the router does
+	// not advertise them.
+	gateway.setSCPD(`<?xml version="1.0"?>
+<scpd xmlns="urn:schemas-upnp-org:service-1-0">
+<actionList>
+<action><name>GetExternalIPAddress</name></action>
+<action><name>GetStatusInfo</name></action>
+</actionList>
+</scpd>`)
+	caps, err = nat.Probe(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected probe error: %v", err)
+	}
+	wantMissing := []string{"AddPortMapping", "DeletePortMapping"}
+	if !reflect.DeepEqual(caps.missing, wantMissing) {
+		t.Fatalf("unexpected missing actions - got %v, want %v",
+			caps.missing, wantMissing)
+	}
+}
+
+// TestUPnPPortMappingTableFull ensures a router reporting a full port mapping
+// table is detected and the number of entries in the table is reported.
+func TestUPnPPortMappingTableFull(t *testing.T) {
+	gateway := newMockGateway()
+	defer gateway.Close()
+	nat := gateway.nat(t)
+	ctx := context.Background()
+
+	// Ensure a mapping succeeds and is not reported as a full table when
+	// the router has room.
+	_, err := nat.AddPortMapping(ctx, "tcp", 9108, 9108, "dcrd listen port",
+		60)
+	if err != nil {
+		t.Fatalf("unexpected error adding port mapping: %v", err)
+	}
+
+	// Ensure a full table is detected and the number of entries is
+	// surfaced.
+	const tableEntries = 128
+	gateway.setTableEntries(tableEntries)
+	_, err = nat.AddPortMapping(ctx, "tcp", 9108, 9108, "dcrd listen port",
+		60)
+	if !isPortMappingTableFull(err) {
+		t.Fatalf("expected full port mapping table error, got %v", err)
+	}
+	entries, err := nat.PortMappingNumberOfEntries(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error querying number of entries: %v", err)
+	}
+	if entries != tableEntries {
+		t.Fatalf("unexpected number of entries - got %d, want %d", entries,
+			tableEntries)
+	}
+}
+
+// TestUPnPExternalPort ensures the ports used to map the listening port via
+// UPnP default to the listening port, honor a configured external port, and
+// are the ones requested when adding and removing the mapping.
+func TestUPnPExternalPort(t *testing.T) {
+	gateway := newMockGateway()
+	defer gateway.Close()
+	nat := gateway.nat(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name         string
+		listenPort   string
+		externalPort uint16
+		wantInternal int
+		wantExternal int
+		wantErr      bool
+	}{{
+		name:         "default to listening port",
+		listenPort:   "9108",
+		wantInternal: 9108,
+		wantExternal: 9108,
+	}, {
+		name:         "configured external port",
+		listenPort:   "9108",
+		externalPort: 19108,
+		wantInternal: 9108,
+		wantExternal: 19108,
+	}, {
+		name:         "high listening port",
+		listenPort:   "40000",
+		wantInternal: 40000,
+		wantExternal: 40000,
+	}, {
+		name:       "invalid listening port",
+		listenPort: "notaport",
+		wantErr:    true,
+	}, {
+		name:       "out of range listening port",
+		listenPort: "65536",
+		wantErr:    true,
+	}}
+
+	for _, test := range tests {
+		internal, external, err := upnpMappingPorts(test.listenPort,
+			test.externalPort)
+		if (err != nil) != test.wantErr {
+			t.Fatalf("%s: unexpected error - got %v, want error %v",
+				test.name, err, test.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		if internal != test.wantInternal || external != test.wantExternal {
+			t.Fatalf("%s: unexpected ports - got %d->%d, want %d->%d",
+				test.name, external, internal, test.wantExternal,
+				test.wantInternal)
+		}
+
+		// Ensure the mapping requests the selected ports and the mapped
+		// port, which is the one advertised to peers, is the external
+		// port.
+		mapped, err := nat.AddPortMapping(ctx, "tcp", external, internal,
+			"dcrd listen port", 60)
+		if err != nil {
+			t.Fatalf("%s: unexpected error adding port mapping: %v",
+				test.name, err)
+		}
+		if mapped != test.wantExternal {
+			t.Fatalf("%s: unexpected mapped external port - got %d, "+
+				"want %d", test.name, mapped, test.wantExternal)
+		}
+		var addReq struct {
+			ExternalPort int `xml:"Body>AddPortMapping>NewExternalPort"`
+			InternalPort int `xml:"Body>AddPortMapping>NewInternalPort"`
+		}
+		err = xml.Unmarshal([]byte(gateway.lastRequest()), &addReq)
+		if err != nil {
+			t.Fatalf("%s: unable to parse add request: %v", test.name, err)
+		}
+		if addReq.ExternalPort != test.wantExternal {
+			t.Fatalf("%s: unexpected requested external port - got %d, "+
+				"want %d", test.name, addReq.ExternalPort, test.wantExternal)
+		}
+		if addReq.InternalPort != test.wantInternal {
+			t.Fatalf("%s: unexpected requested internal port - got %d, "+
+				"want %d", test.name, addReq.InternalPort, test.wantInternal)
+		}
+
+		// Ensure removing the mapping requests the external port.
+		err = nat.DeletePortMapping(ctx, "tcp", external, internal)
+		if err != nil {
+			t.Fatalf("%s: unexpected error removing port mapping: %v",
+				test.name, err)
+		}
+		var delReq struct {
+			ExternalPort int `xml:"Body>DeletePortMapping>NewExternalPort"`
+		}
+		err = xml.Unmarshal([]byte(gateway.lastRequest()), &delReq)
+		if err != nil {
+			t.Fatalf("%s: unable to parse delete request: %v", test.name,
+				err)
+		}
+		if delReq.ExternalPort != test.wantExternal {
+			t.Fatalf("%s: unexpected removed external port - got %d, "+
+				"want %d", test.name, delReq.ExternalPort, test.wantExternal)
+		}
+	}
+}
+
+// TestUPnPCancel ensures in-flight UPnP requests return promptly once their
+// context is canceled and that the outstanding request is abandoned.
+func TestUPnPCancel(t *testing.T) {
+	// Create a router that never replies to SOAP requests and reports when
+	// a request it is handling is abandoned by the client.
+	abandoned := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The request body must be consumed for the server to notice
+		// the client abandoning the connection.
+		ioutil.ReadAll(r.Body)
+		<-r.Context().Done()
+		abandoned <- struct{}{}
+	}))
+	defer srv.Close()
+	nat := &upnpNAT{serviceURL: srv.URL, ourIP: "192.168.1.2"}
+
+	tests := []struct {
+		name string
+		f    func(ctx context.Context) error
+	}{{
+		name: "GetExternalAddress",
+		f: func(ctx context.Context) error {
+			_, err := nat.GetExternalAddress(ctx)
+			return err
+		},
+	}, {
+		name: "AddPortMapping",
+		f: func(ctx context.Context) error {
+			_, err := nat.AddPortMapping(ctx, "tcp", 9108, 9108,
+				"dcrd listen port", 60)
+			return err
+		},
+	}, {
+		name: "DeletePortMapping",
+		f: func(ctx context.Context) error {
+			return nat.DeletePortMapping(ctx, "tcp", 9108, 9108)
+		},
+	}, {
+		name: "getServiceURL",
+		f: func(ctx context.Context) error {
+			_, _, err := getServiceURL(ctx, srv.URL)
+			return err
+		},
+	}}
+
+	for _, test := range tests {
+		ctx, cancel := context.WithCancel(context.Background())
+		errChan := make(chan error, 1)
+		go func() {
+			errChan <- test.f(ctx)
+		}()
+
+		time.AfterFunc(50*time.Millisecond, cancel)
+		select {
+		case err := <-errChan:
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("%s: unexpected error - got %v, want %v",
+					test.name, err, context.Canceled)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: did not return after cancellation", test.name)
+		}
+
+		select {
+		case <-abandoned:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: request was not abandoned", test.name)
+		}
+	}
+}